@@ -0,0 +1,52 @@
+package container
+
+// Well-known column names that can be requested through [TopOptions.Columns]
+// and that appear as keys in [TopResponse.Titles]. Daemons are free to
+// support additional columns; these are the ones guaranteed to be portable
+// across platforms and graphdrivers.
+const (
+	TopColumnUser    = "USER"
+	TopColumnPID     = "PID"
+	TopColumnPPID    = "PPID"
+	TopColumnPCPU    = "%CPU"
+	TopColumnPMem    = "%MEM"
+	TopColumnSTime   = "STIME"
+	TopColumnTTY     = "TTY"
+	TopColumnTime    = "TIME"
+	TopColumnCommand = "COMMAND"
+	TopColumnWChan   = "WCHAN"
+	TopColumnRSS     = "RSS"
+	TopColumnState   = "STATE"
+)
+
+// TopOptions holds the options accepted by ContainerTop.
+type TopOptions struct {
+	// Columns lists the structured columns to collect for each process, using
+	// the TopColumn* names (e.g. "PID", "%CPU"). When empty, PSArgs is used
+	// instead for backwards compatibility with clients that have not been
+	// updated to request columns explicitly.
+	Columns []string
+
+	// PSArgs is the historical ps(1)-style argument string (e.g. "aux", or
+	// "-eo pid,user"). It is translated to an equivalent Columns set on a
+	// best-effort basis: "", "-ef", "aux" and -o/-eo/-O field lists built
+	// from the keywords in ps(1)'s STANDARD FORMAT SPECIFIERS are honored,
+	// anything else falls back to the same columns as "-ef".
+	//
+	// Deprecated: use Columns instead.
+	PSArgs string
+}
+
+// TopResponse ContainerTopResponse
+//
+// Container "top" response.
+// swagger:model TopResponse
+type TopResponse struct {
+
+	// Each process running in the container, where each is process
+	// is an array of values corresponding to the titles.
+	Processes [][]string `json:"Processes"`
+
+	// The ps column titles
+	Titles []string `json:"Titles"`
+}
@@ -0,0 +1,25 @@
+package graphdriver
+
+import "io"
+
+// BackupDriver is an optional capability a Driver may implement to produce
+// and consume a storage-driver-native backup stream per layer instead of a
+// tar archive. The daemon probes for it with a type assertion before
+// falling back to its own tar-based save/restore path, so drivers that
+// don't implement it keep working unchanged.
+//
+// The stream a BackupDriver produces is only guaranteed to be restorable
+// against the same driver instance (and, for driver implementations whose
+// delta format is filesystem-scoped, the same backing filesystem) it came
+// from -- it is a faster local save/restore path, not a portable format for
+// moving layers between hosts.
+type BackupDriver interface {
+	// BackupLayer writes a backup stream for the layer id to w, using
+	// parent (if any) as a delta baseline the same way Diff does.
+	BackupLayer(id, parent string, w io.Writer) error
+
+	// RestoreLayer re-materializes a stream written by BackupLayer as the
+	// layer id. It must reject a stream that doesn't match id/parent, or
+	// that was produced against an incompatible filesystem.
+	RestoreLayer(id, parent string, r io.Reader) error
+}
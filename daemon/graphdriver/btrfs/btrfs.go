@@ -37,6 +37,7 @@ import (
 	"github.com/containerd/log"
 	"github.com/docker/go-units"
 	"github.com/moby/moby/v2/daemon/graphdriver"
+	"github.com/moby/moby/v2/daemon/graphdriver/quota/projectquota"
 	"github.com/moby/moby/v2/daemon/internal/containerfs"
 	"github.com/moby/moby/v2/daemon/internal/fstype"
 	"github.com/moby/sys/mount"
@@ -54,6 +55,14 @@ func init() {
 type btrfsOptions struct {
 	minSpace uint64
 	size     uint64
+
+	// compression is a btrfs compression property value, e.g. "zstd:3",
+	// "lzo", "zlib", or "none" to explicitly disable an inherited value.
+	// Empty means "leave whatever the parent subvolume already has".
+	compression string
+	// nodatacow disables copy-on-write for files created under the
+	// subvolume, via FS_NOCOW_FL ("chattr +C").
+	nodatacow bool
 }
 
 // Init returns a new BTRFS driver.
@@ -108,7 +117,13 @@ func Init(home string, options []string, idMap user.IdentityMapping) (graphdrive
 		}
 	}
 
-	return graphdriver.NewNaiveDiffDriver(driver, driver.idMap), nil
+	// naiveDiff backs Diff/Changes/ApplyDiff whenever the native btrfs
+	// send/receive path can't be used -- e.g. after `docker load` breaks
+	// the parent chain, or id/parent aren't btrfs subvolumes at all -- and
+	// always backs DiffSize, which has no native path.
+	driver.naiveDiff = graphdriver.NewNaiveDiffDriver(driver, driver.idMap)
+
+	return driver, nil
 }
 
 func parseOptions(opt []string) (btrfsOptions, bool, error) {
@@ -128,6 +143,18 @@ func parseOptions(opt []string) (btrfsOptions, bool, error) {
 			}
 			userDiskQuota = true
 			options.minSpace = uint64(minSpace)
+		case "btrfs.compression":
+			compression, err := normalizeCompression(val)
+			if err != nil {
+				return options, userDiskQuota, err
+			}
+			options.compression = compression
+		case "btrfs.nodatacow":
+			nodatacow, err := strconv.ParseBool(val)
+			if err != nil {
+				return options, userDiskQuota, err
+			}
+			options.nodatacow = nodatacow
 		default:
 			return options, userDiskQuota, fmt.Errorf("Unknown option %s", key)
 		}
@@ -135,6 +162,32 @@ func parseOptions(opt []string) (btrfsOptions, bool, error) {
 	return options, userDiskQuota, nil
 }
 
+// btrfsCompressionXattr is the xattr btrfs itself uses to store a
+// subvolume/file's compression property, mirroring what `btrfs property
+// set <path> compress <value>` does under the hood.
+const btrfsCompressionXattr = "btrfs.compression"
+
+// normalizeCompression validates a btrfs.compression storage opt value,
+// one of the algorithms accepted by `btrfs property set ... compress`,
+// optionally followed by a ":level" (only meaningful for zstd), or
+// "none" to explicitly turn compression off.
+func normalizeCompression(val string) (string, error) {
+	val = strings.ToLower(val)
+	algo := val
+	if i := strings.IndexByte(val, ':'); i != -1 {
+		algo = val[:i]
+		if _, err := strconv.Atoi(val[i+1:]); err != nil {
+			return "", fmt.Errorf("btrfs: invalid compression level in %q: %v", val, err)
+		}
+	}
+	switch algo {
+	case "zstd", "lzo", "zlib", "none":
+		return val, nil
+	default:
+		return "", fmt.Errorf("btrfs: unknown compression algorithm %q", algo)
+	}
+}
+
 // Driver contains information about the filesystem mounted.
 type Driver struct {
 	// root of the file system
@@ -143,6 +196,18 @@ type Driver struct {
 	options      btrfsOptions
 	quotaEnabled bool
 	once         sync.Once
+
+	// naiveDiff is the directory-walk fallback for Diff/Changes/ApplyDiff,
+	// used whenever the native btrfs send/receive path isn't available for
+	// a given id/parent pair, and the only implementation of DiffSize.
+	naiveDiff graphdriver.DiffDriver
+
+	// projectQuotaOnce guards lazily probing d.subvolumesDir() for XFS/ext4
+	// project quota support, used as a fallback when btrfs qgroups can't be
+	// enabled (e.g. a rootless daemon, or d.home itself a nested subvolume).
+	projectQuotaOnce sync.Once
+	projectQuotaCtl  *projectquota.Control
+	projectQuotaErr  error
 }
 
 // String prints the name of the driver (btrfs).
@@ -244,6 +309,57 @@ func subvolSnapshot(src, dest, name string) error {
 	return nil
 }
 
+// subvolFlags returns the BTRFS_IOC_SUBVOL_GETFLAGS flags bitmask for the
+// subvolume at path -- currently only BTRFS_SUBVOL_RDONLY is meaningful to
+// this driver.
+func subvolFlags(p string) (uint64, error) {
+	dir, err := openDir(p)
+	if err != nil {
+		return 0, err
+	}
+	defer closeDir(dir)
+
+	var flags C.__u64
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, getDirFd(dir), C.BTRFS_IOC_SUBVOL_GETFLAGS,
+		uintptr(unsafe.Pointer(&flags)))
+	if errno != 0 {
+		return 0, fmt.Errorf("Failed to get btrfs subvolume flags for %s: %v", p, errno.Error())
+	}
+	return uint64(flags), nil
+}
+
+// setSubvolReadOnly sets or clears the BTRFS_SUBVOL_RDONLY flag on the
+// subvolume at path via BTRFS_IOC_SUBVOL_SETFLAGS. Create uses this to seal
+// image layers against mutation once they're fully built, since a genuinely
+// read-only parent is what makes it safe to snapshot it for children;
+// CreateReadWrite and Get clear it again for the one writable container
+// layer on top.
+func setSubvolReadOnly(p string, readonly bool) error {
+	flags, err := subvolFlags(p)
+	if err != nil {
+		return err
+	}
+	if readonly {
+		flags |= uint64(C.BTRFS_SUBVOL_RDONLY)
+	} else {
+		flags &^= uint64(C.BTRFS_SUBVOL_RDONLY)
+	}
+
+	dir, err := openDir(p)
+	if err != nil {
+		return err
+	}
+	defer closeDir(dir)
+
+	cflags := C.__u64(flags)
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, getDirFd(dir), C.BTRFS_IOC_SUBVOL_SETFLAGS,
+		uintptr(unsafe.Pointer(&cflags)))
+	if errno != 0 {
+		return fmt.Errorf("Failed to set btrfs subvolume flags for %s: %v", p, errno.Error())
+	}
+	return nil
+}
+
 func isSubvolume(p string) (bool, error) {
 	var bufStat unix.Stat_t
 	if err := unix.Lstat(p, &bufStat); err != nil {
@@ -294,7 +410,7 @@ func subvolDelete(dirpath, name string, quotaEnabled bool) error {
 	}
 
 	if quotaEnabled {
-		if qgroupid, err := subvolLookupQgroup(fullPath); err == nil {
+		if qgroupid, err := subvolRootID(fullPath); err == nil {
 			var args C.struct_btrfs_ioctl_qgroup_create_args
 			args.qgroupid = C.__u64(qgroupid)
 
@@ -308,6 +424,14 @@ func subvolDelete(dirpath, name string, quotaEnabled bool) error {
 		}
 	}
 
+	// An image layer's subvolume is marked read-only by Create (see
+	// setSubvolReadOnly); clear the flag before destroying it, since some
+	// kernels refuse BTRFS_IOC_SNAP_DESTROY on a subvolume that's still
+	// flagged read-only.
+	if err := setSubvolReadOnly(fullPath, false); err != nil {
+		log.G(context.TODO()).WithField("storage-driver", "btrfs").Errorf("Failed to clear read-only flag on %s before destroying it: %v", fullPath, err)
+	}
+
 	// all subvolumes have been removed
 	// now remove the one originally passed in
 	for i, c := range []byte(name) {
@@ -434,7 +558,10 @@ func qgroupStatus(path string) error {
 	return nil
 }
 
-func subvolLookupQgroup(path string) (uint64, error) {
+// subvolRootID returns the btrfs root (subvolume) object ID for the
+// subvolume at path -- the ID used to address its qgroup, and to address
+// its own tree directly via BTRFS_IOC_TREE_SEARCH for native diffing.
+func subvolRootID(path string) (uint64, error) {
 	dir, err := openDir(path)
 	if err != nil {
 		return 0, err
@@ -447,10 +574,10 @@ func subvolLookupQgroup(path string) (uint64, error) {
 	_, _, errno := unix.Syscall(unix.SYS_IOCTL, getDirFd(dir), C.BTRFS_IOC_INO_LOOKUP,
 		uintptr(unsafe.Pointer(&args)))
 	if errno != 0 {
-		return 0, fmt.Errorf("Failed to lookup qgroup for %s: %v", dir, errno.Error())
+		return 0, fmt.Errorf("Failed to lookup root id for %s: %v", dir, errno.Error())
 	}
 	if args.treeid == 0 {
-		return 0, fmt.Errorf("Invalid qgroup id for %s: 0", dir)
+		return 0, fmt.Errorf("Invalid root id for %s: 0", dir)
 	}
 
 	return uint64(args.treeid), nil
@@ -472,13 +599,50 @@ func (d *Driver) quotasDirID(id string) string {
 	return path.Join(d.quotasDir(), id)
 }
 
+func (d *Driver) propsDir() string {
+	return path.Join(d.home, "props")
+}
+
+func (d *Driver) propsDirID(id string) string {
+	return path.Join(d.propsDir(), id)
+}
+
+func (d *Driver) rwDir() string {
+	return path.Join(d.home, "rw")
+}
+
+func (d *Driver) rwDirID(id string) string {
+	return path.Join(d.rwDir(), id)
+}
+
 // CreateReadWrite creates a layer that is writable for use as a container
-// file system.
+// file system. Create seals every layer read-only once it's built, so here
+// that's cleared back off on the new snapshot, and a marker is left under
+// rw/<id> -- both so a daemon restart doesn't reapply the flag out from
+// under a running container, and so Get knows to keep clearing it if
+// something (e.g. a buggy 3rd-party tool poking the subvolume directly)
+// ever sets it again.
 func (d *Driver) CreateReadWrite(id, parent string, opts *graphdriver.CreateOpts) error {
-	return d.Create(id, parent, opts)
+	if err := d.Create(id, parent, opts); err != nil {
+		return err
+	}
+	if err := setSubvolReadOnly(d.subvolumesDirID(id), false); err != nil {
+		return err
+	}
+
+	rwDir := d.rwDir()
+	if err := user.MkdirAllAndChown(rwDir, 0o700, os.Getuid(), os.Getegid()); err != nil {
+		return err
+	}
+	return os.WriteFile(d.rwDirID(id), nil, 0o644)
 }
 
-// Create the filesystem with given id.
+// Create the filesystem with given id. The resulting subvolume is sealed
+// read-only (see setSubvolReadOnly) once it's fully set up, so that once it
+// becomes a parent for a later layer, nothing -- including a bug in Diff,
+// ApplyDiff, or an inadvertent write through Get -- can mutate it out from
+// under its children. CreateReadWrite clears the flag back off for the one
+// layer in a chain that's meant to stay writable, a container's own layer.
 func (d *Driver) Create(id, parent string, opts *graphdriver.CreateOpts) error {
 	quotas := path.Join(d.home, "quotas")
 	subvolumes := path.Join(d.home, "subvolumes")
@@ -510,12 +674,23 @@ func (d *Driver) Create(id, parent string, opts *graphdriver.CreateOpts) error {
 		storageOpt = opts.StorageOpt
 	}
 
-	if _, ok := storageOpt["size"]; ok {
-		driver := &Driver{}
-		if err := d.parseStorageOpt(storageOpt, driver); err != nil {
-			return err
-		}
+	driver := &Driver{options: d.options}
+	if err := d.parseStorageOpt(storageOpt, driver); err != nil {
+		return err
+	}
+
+	// nodatacow only takes effect for files created after the flag is
+	// set, and compression is inherited by files from their parent
+	// directory's xattr, so both must be applied to the still-empty
+	// subvolume before anything else writes into it.
+	if err := d.applyProps(path.Join(subvolumes, id), driver.options); err != nil {
+		return err
+	}
+	if err := d.persistProps(id, driver.options); err != nil {
+		return err
+	}
 
+	if driver.options.size != 0 {
 		if err := d.setStorageSize(path.Join(subvolumes, id), driver); err != nil {
 			return err
 		}
@@ -540,7 +715,15 @@ func (d *Driver) Create(id, parent string, opts *graphdriver.CreateOpts) error {
 		mountLabel = opts.MountLabel
 	}
 
-	return label.Relabel(path.Join(subvolumes, id), mountLabel, false)
+	if err := label.Relabel(path.Join(subvolumes, id), mountLabel, false); err != nil {
+		return err
+	}
+
+	// Seal the layer read-only now that it's fully built. ApplyDiff (the
+	// naiveDiff fallback in particular) still needs to write into this
+	// same subvolume afterwards, so it's responsible for temporarily
+	// clearing and restoring this flag around that write.
+	return setSubvolReadOnly(path.Join(subvolumes, id), true)
 }
 
 // Parse btrfs storage options
@@ -555,6 +738,18 @@ func (d *Driver) parseStorageOpt(storageOpt map[string]string, driver *Driver) e
 				return err
 			}
 			driver.options.size = uint64(size)
+		case "btrfs.compression":
+			compression, err := normalizeCompression(val)
+			if err != nil {
+				return err
+			}
+			driver.options.compression = compression
+		case "btrfs.nodatacow":
+			nodatacow, err := strconv.ParseBool(val)
+			if err != nil {
+				return err
+			}
+			driver.options.nodatacow = nodatacow
 		default:
 			return fmt.Errorf("Unknown option %s", key)
 		}
@@ -571,10 +766,137 @@ func (d *Driver) setStorageSize(dir string, driver *Driver) error {
 	if d.options.minSpace > 0 && driver.options.size < d.options.minSpace {
 		return fmt.Errorf("btrfs: storage size cannot be less than %s", units.HumanSize(float64(d.options.minSpace)))
 	}
-	if err := d.enableQuota(); err != nil {
+	return d.limitSize(dir, driver.options.size)
+}
+
+// limitSize applies a size limit to dir, preferring a btrfs qgroup but
+// transparently falling back to an XFS/ext4 project quota on the
+// underlying filesystem when qgroups can't be enabled here -- common in
+// rootless/user namespace setups, or when d.home is itself a nested
+// subvolume without quota support.
+func (d *Driver) limitSize(dir string, size uint64) error {
+	if err := d.enableQuota(); err == nil {
+		return subvolLimitQgroup(dir, size)
+	}
+
+	ctl, err := d.projectQuotaControl()
+	if err != nil {
+		return err
+	}
+	return ctl.SetQuota(dir, projectquota.Quota{Size: size})
+}
+
+// projectQuotaControl lazily probes d.subvolumesDir() for project quota
+// support, caching the result (or the probe's failure) for the driver's
+// lifetime. The Control is rooted at the subvolumes directory because its
+// immediate children are the layer paths limitSize hands to SetQuota, which
+// is where NewControl looks for project IDs tagged before a restart.
+func (d *Driver) projectQuotaControl() (*projectquota.Control, error) {
+	d.projectQuotaOnce.Do(func() {
+		d.projectQuotaCtl, d.projectQuotaErr = projectquota.NewControl(d.subvolumesDir())
+	})
+	return d.projectQuotaCtl, d.projectQuotaErr
+}
+
+// ioctlGetFlags and ioctlSetFlags wrap the generic (non-btrfs-specific)
+// FS_IOC_GETFLAGS/FS_IOC_SETFLAGS ioctls used to read/write the inode
+// attribute bits `chattr` manipulates, e.g. FS_NOCOW_FL for nodatacow.
+func ioctlGetFlags(fd int, flags *uint32) error {
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), unix.FS_IOC_GETFLAGS, uintptr(unsafe.Pointer(flags)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func ioctlSetFlags(fd int, flags uint32) error {
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), unix.FS_IOC_SETFLAGS, uintptr(unsafe.Pointer(&flags)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// applyProps sets the compression and nodatacow properties on dir, the
+// still-empty subvolume just created by subvolCreate/subvolSnapshot.
+// Compression is stored as an xattr btrfs resolves as the subvolume's
+// "compress" property; nodatacow is the FS_NOCOW_FL inode flag set by
+// `chattr +C`. Both only affect files written after they're applied, so
+// callers must run this before anything else writes into dir.
+func (d *Driver) applyProps(dir string, options btrfsOptions) error {
+	if options.compression != "" {
+		if err := unix.Setxattr(dir, btrfsCompressionXattr, []byte(options.compression), 0); err != nil {
+			return errors.Wrapf(err, "failed to set %s=%s on %s", btrfsCompressionXattr, options.compression, dir)
+		}
+	}
+	if options.nodatacow {
+		fd, err := unix.Open(dir, unix.O_RDONLY, 0)
+		if err != nil {
+			return errors.Wrapf(err, "failed to open %s to set nodatacow", dir)
+		}
+		defer unix.Close(fd)
+
+		var flags uint32
+		if err := ioctlGetFlags(fd, &flags); err != nil {
+			return errors.Wrapf(err, "failed to get inode flags for %s", dir)
+		}
+		flags |= unix.FS_NOCOW_FL
+		if err := ioctlSetFlags(fd, flags); err != nil {
+			return errors.Wrapf(err, "failed to set nodatacow on %s", dir)
+		}
+	}
+	return nil
+}
+
+// persistProps writes the non-default compression/nodatacow options for
+// id to props/<id>, alongside quotas/<id>, so Get can re-apply them to
+// the subvolume's xattr/inode flags after a daemon restart -- those
+// aren't tracked anywhere else once set.
+func (d *Driver) persistProps(id string, options btrfsOptions) error {
+	propsDir := d.propsDir()
+	propsFile := d.propsDirID(id)
+
+	if options.compression == "" && !options.nodatacow {
+		if err := os.Remove(propsFile); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	if err := user.MkdirAllAndChown(propsDir, 0o700, os.Getuid(), os.Getegid()); err != nil {
 		return err
 	}
-	return subvolLimitQgroup(dir, driver.options.size)
+	data := fmt.Sprintf("compression=%s\nnodatacow=%t\n", options.compression, options.nodatacow)
+	return os.WriteFile(propsFile, []byte(data), 0o644)
+}
+
+// loadProps reads back the options persisted by persistProps for id, if
+// any were ever set.
+func (d *Driver) loadProps(id string) (btrfsOptions, error) {
+	var options btrfsOptions
+	data, err := os.ReadFile(d.propsDirID(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return options, nil
+		}
+		return options, err
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		key, val, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "compression":
+			options.compression = val
+		case "nodatacow":
+			options.nodatacow, err = strconv.ParseBool(val)
+			if err != nil {
+				return options, err
+			}
+		}
+	}
+	return options, nil
 }
 
 // Remove the filesystem with given id.
@@ -629,10 +951,38 @@ func (d *Driver) Get(id, mountLabel string) (string, error) {
 
 	if quota, err := os.ReadFile(d.quotasDirID(id)); err == nil {
 		if size, err := strconv.ParseUint(string(quota), 10, 64); err == nil && size >= d.options.minSpace {
-			if err := d.enableQuota(); err != nil {
+			if err := d.limitSize(dir, size); err != nil {
 				return "", err
 			}
-			if err := subvolLimitQgroup(dir, size); err != nil {
+		}
+	}
+
+	// id's presence under rw/ means CreateReadWrite built this layer as
+	// the writable one in its chain, and it must stay mutable no matter
+	// what last touched its RDONLY flag -- including a daemon that
+	// restarted between CreateReadWrite clearing the flag and a container
+	// actually starting, or an older on-disk layer that predates this
+	// flag and is still (harmlessly) RW already. This has to run before
+	// applyProps below: the xattr write (and, for nodatacow, the ioctl)
+	// it does both fail with EROFS while the subvolume is still sealed.
+	_, rwStatErr := os.Stat(d.rwDirID(id))
+	isRWLayer := rwStatErr == nil
+	if isRWLayer {
+		if err := setSubvolReadOnly(dir, false); err != nil {
+			return "", err
+		}
+	}
+
+	// Compression and nodatacow are properties of the filesystem, not
+	// tracked by the daemon anywhere else, so they must be re-applied
+	// from props/<id> on every Get -- e.g. after a daemon restart. Only
+	// the container's own rw/ layer needs this: image/parent layers are
+	// sealed read-only by Create and already had these properties applied
+	// once, before they were sealed, so re-applying here would just fail
+	// against the now-immutable subvolume.
+	if isRWLayer {
+		if props, err := d.loadProps(id); err == nil {
+			if err := d.applyProps(dir, props); err != nil {
 				return "", err
 			}
 		}
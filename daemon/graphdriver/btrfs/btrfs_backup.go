@@ -0,0 +1,158 @@
+//go:build linux
+
+package btrfs
+
+/*
+#include <linux/version.h>
+#if LINUX_VERSION_CODE < KERNEL_VERSION(4,12,0)
+    #error "Headers from kernel >= 4.12 are required to build with Btrfs support."
+    #error "HINT: Set 'DOCKER_BUILDTAGS=exclude_graphdriver_btrfs' to build without Btrfs."
+#endif
+
+#include <linux/btrfs.h>
+*/
+import "C"
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"unsafe"
+
+	"github.com/moby/moby/v2/daemon/graphdriver"
+	"golang.org/x/sys/unix"
+)
+
+var _ graphdriver.BackupDriver = (*Driver)(nil)
+
+// backupStreamMagic prefixes every stream BackupLayer writes, so
+// RestoreLayer can immediately reject input that isn't one.
+var backupStreamMagic = []byte("moby-btrfs-backup-stream-v1\n")
+
+// backupHeader is serialized as a single JSON line right after
+// backupStreamMagic, identifying what the following btrfs send stream is a
+// backup of so RestoreLayer can refuse a mismatched parent chain or
+// filesystem before it ever calls `btrfs receive`.
+type backupHeader struct {
+	ID     string `json:"id"`
+	Parent string `json:"parent"`
+	FSUUID string `json:"fs_uuid"`
+}
+
+// BackupLayer writes a btrfs send stream for the layer id to w, using
+// parent (if any) as a clone source exactly as Diff does, preceded by a
+// header recording the btrfs filesystem UUID and the id/parent chain so
+// RestoreLayer can refuse to apply it against a mismatched parent or
+// filesystem.
+//
+// The stream RestoreLayer will accept is tied to the filesystem it was
+// produced on: resolving parent's clone source is done by looking up its
+// root ID on this filesystem, so an incremental stream can only ever be
+// restored where that parent subvolume already exists. That makes this
+// capability a faster local save/restore path, not a migration format for
+// moving layers to a different host or a different btrfs filesystem --
+// unlike `btrfs send`/`receive` used directly against a received-UUID
+// match, nothing here makes the stream portable across filesystems.
+func (d *Driver) BackupLayer(id, parent string, w io.Writer) error {
+	uuid, err := btrfsFilesystemUUID(d.home)
+	if err != nil {
+		return fmt.Errorf("btrfs backup: %w", err)
+	}
+
+	header, err := json.Marshal(backupHeader{ID: id, Parent: parent, FSUUID: hex.EncodeToString(uuid[:])})
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(backupStreamMagic); err != nil {
+		return err
+	}
+	if _, err := w.Write(append(header, '\n')); err != nil {
+		return err
+	}
+
+	var parentRootID uint64
+	if parent != "" {
+		parentRootID, err = subvolRootID(d.subvolumesDirID(parent))
+		if err != nil {
+			return fmt.Errorf("btrfs backup: resolving parent %s: %w", parent, err)
+		}
+	}
+	return btrfsSend(d.subvolumesDirID(id), parentRootID, parent != "", w)
+}
+
+// RestoreLayer re-materializes a stream written by BackupLayer as the
+// subvolume id, rejecting it if its header's id/parent or filesystem UUID
+// don't match this driver and the requested parent.
+func (d *Driver) RestoreLayer(id, parent string, r io.Reader) error {
+	br := bufio.NewReader(r)
+
+	magic := make([]byte, len(backupStreamMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return fmt.Errorf("btrfs restore: reading stream header: %w", err)
+	}
+	if !bytes.Equal(magic, backupStreamMagic) {
+		return fmt.Errorf("btrfs restore: input is not a btrfs backup stream")
+	}
+
+	headerLine, err := br.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("btrfs restore: reading stream header: %w", err)
+	}
+	var header backupHeader
+	if err := json.Unmarshal([]byte(headerLine), &header); err != nil {
+		return fmt.Errorf("btrfs restore: parsing stream header: %w", err)
+	}
+	if header.ID != id {
+		return fmt.Errorf("btrfs restore: stream is for layer %q, not %q", header.ID, id)
+	}
+	if header.Parent != parent {
+		return fmt.Errorf("btrfs restore: stream's parent %q does not match requested parent %q", header.Parent, parent)
+	}
+	uuid, err := btrfsFilesystemUUID(d.home)
+	if err != nil {
+		return fmt.Errorf("btrfs restore: %w", err)
+	}
+	if header.FSUUID != hex.EncodeToString(uuid[:]) {
+		return fmt.Errorf("btrfs restore: stream was produced on a different btrfs filesystem")
+	}
+
+	if d.Exists(id) {
+		if err := subvolDelete(d.subvolumesDir(), id, d.quotaEnabled); err != nil {
+			return fmt.Errorf("btrfs restore: removing existing subvolume %s: %w", id, err)
+		}
+	}
+
+	// header.ID == id was already verified above, so the subvolume name
+	// baked into the stream by BackupLayer (the basename of the id it was
+	// captured from) is guaranteed to match id here -- but route through
+	// the same scratch-receive-then-rename helper ApplyDiff uses anyway,
+	// rather than relying on that being true forever.
+	if err := btrfsReceive(d.subvolumesDir(), d.subvolumesDirID(id), br); err != nil {
+		return fmt.Errorf("btrfs restore: receiving stream for %s: %w", id, err)
+	}
+	return nil
+}
+
+// btrfsFilesystemUUID returns the btrfs filesystem UUID for the filesystem
+// mounted at home, via BTRFS_IOC_FS_INFO.
+func btrfsFilesystemUUID(home string) ([16]byte, error) {
+	var uuid [16]byte
+
+	dir, err := openDir(home)
+	if err != nil {
+		return uuid, err
+	}
+	defer closeDir(dir)
+
+	var args C.struct_btrfs_ioctl_fs_info_args
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, getDirFd(dir), C.BTRFS_IOC_FS_INFO, uintptr(unsafe.Pointer(&args))); errno != 0 {
+		return uuid, fmt.Errorf("Failed to get btrfs filesystem info for %s: %v", home, errno.Error())
+	}
+	for i := range uuid {
+		uuid[i] = byte(args.fsid[i])
+	}
+	return uuid, nil
+}
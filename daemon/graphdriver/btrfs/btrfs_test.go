@@ -0,0 +1,110 @@
+//go:build linux
+
+package btrfs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/moby/moby/v2/daemon/graphdriver"
+	"github.com/moby/sys/user"
+)
+
+// newTestDriver returns an Init'd Driver rooted in a fresh temp directory,
+// or skips the test if that directory isn't backed by btrfs -- the same
+// ErrPrerequisites signal Init gives a non-btrfs daemon home.
+func newTestDriver(t *testing.T) *Driver {
+	t.Helper()
+	home := t.TempDir()
+	d, err := Init(home, nil, user.IdentityMapping{})
+	if errors.Is(err, graphdriver.ErrPrerequisites) {
+		t.Skip("test requires a btrfs-backed temp directory")
+	}
+	if err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	return d.(*Driver)
+}
+
+// TestCreateSealsParentReadOnly asserts the immutability guarantee chunk1-5
+// exists for: once a layer has a child snapshotted off it, nothing can
+// write into the parent's subvolume through the mount Get returns.
+func TestCreateSealsParentReadOnly(t *testing.T) {
+	d := newTestDriver(t)
+
+	if err := d.Create("parent", "", nil); err != nil {
+		t.Fatalf("Create(parent): %v", err)
+	}
+	if err := d.Create("child", "parent", nil); err != nil {
+		t.Fatalf("Create(child): %v", err)
+	}
+
+	parentMount, err := d.Get("parent", "")
+	if err != nil {
+		t.Fatalf("Get(parent): %v", err)
+	}
+	defer d.Put("parent")
+
+	if err := os.WriteFile(filepath.Join(parentMount, "mutate-me"), []byte("x"), 0o644); err == nil {
+		t.Fatal("expected write into a sealed parent subvolume to fail, it succeeded")
+	}
+}
+
+// TestCreateReadWriteIsWritable asserts the other half of the split: the
+// one layer CreateReadWrite produces for a container's own filesystem must
+// stay writable even though its parent is sealed.
+func TestCreateReadWriteIsWritable(t *testing.T) {
+	d := newTestDriver(t)
+
+	if err := d.Create("base", "", nil); err != nil {
+		t.Fatalf("Create(base): %v", err)
+	}
+	if err := d.CreateReadWrite("container", "base", nil); err != nil {
+		t.Fatalf("CreateReadWrite(container): %v", err)
+	}
+
+	mnt, err := d.Get("container", "")
+	if err != nil {
+		t.Fatalf("Get(container): %v", err)
+	}
+	defer d.Put("container")
+
+	if err := os.WriteFile(filepath.Join(mnt, "ok"), []byte("x"), 0o644); err != nil {
+		t.Fatalf("expected write into the read-write layer to succeed: %v", err)
+	}
+}
+
+// TestGetWithNodatacowStorageOptSucceeds covers the ordering bug where Get
+// re-applied props (which writes an xattr and, for nodatacow, an FS_IOC_
+// SETFLAGS ioctl) before clearing RDONLY on the rw/ layer, and never clears
+// it at all for sealed parent/image layers -- both paths failed with EROFS
+// whenever a layer was created with a btrfs.compression or btrfs.nodatacow
+// storage-opt.
+func TestGetWithNodatacowStorageOptSucceeds(t *testing.T) {
+	d := newTestDriver(t)
+
+	opts := &graphdriver.CreateOpts{StorageOpt: map[string]string{"btrfs.nodatacow": "true"}}
+	if err := d.Create("base", "", opts); err != nil {
+		t.Fatalf("Create(base): %v", err)
+	}
+	if _, err := d.Get("base", ""); err != nil {
+		t.Fatalf("Get(base) on a sealed layer with nodatacow set: %v", err)
+	}
+	d.Put("base")
+
+	if err := d.CreateReadWrite("container", "base", opts); err != nil {
+		t.Fatalf("CreateReadWrite(container): %v", err)
+	}
+	if _, err := d.Get("container", ""); err != nil {
+		t.Fatalf("Get(container) with nodatacow set: %v", err)
+	}
+	d.Put("container")
+
+	// Simulate a daemon restart re-resolving an already-running
+	// container's layer: Get must succeed again, not just the first time.
+	if _, err := d.Get("container", ""); err != nil {
+		t.Fatalf("second Get(container) with nodatacow set: %v", err)
+	}
+}
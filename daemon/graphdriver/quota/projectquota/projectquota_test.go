@@ -0,0 +1,63 @@
+//go:build linux
+
+package projectquota
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestNewControlSkipsWithoutProjectQuotaSupport covers the skip path
+// DriverTestSetQuota-style callers rely on: a filesystem not mounted with
+// prjquota/pquota must report ErrQuotaNotSupported rather than fail in some
+// less specific way.
+func TestNewControlSkipsWithoutProjectQuotaSupport(t *testing.T) {
+	_, err := NewControl(t.TempDir())
+	if err == nil {
+		t.Fatal("expected NewControl on a non-project-quota-capable temp directory to fail")
+	}
+	if !errors.Is(err, ErrQuotaNotSupported) {
+		t.Fatalf("got error %v, want ErrQuotaNotSupported", err)
+	}
+}
+
+func TestHasProjectQuotaOption(t *testing.T) {
+	cases := []struct {
+		opts []string
+		want bool
+	}{
+		{[]string{"rw", "relatime"}, false},
+		{[]string{"rw", "prjquota"}, true},
+		{[]string{"rw", "pquota"}, true},
+	}
+	for _, c := range cases {
+		if got := hasProjectQuotaOption(c.opts); got != c.want {
+			t.Errorf("hasProjectQuotaOption(%v) = %v, want %v", c.opts, got, c.want)
+		}
+	}
+}
+
+// TestScanExistingProjectIDsEmptyDir covers the collision-avoidance fix:
+// with nothing tagged yet, the next ID allocated must still start at 1.
+func TestScanExistingProjectIDsEmptyDir(t *testing.T) {
+	quotas, next, err := scanExistingProjectIDs(t.TempDir())
+	if err != nil {
+		t.Fatalf("scanExistingProjectIDs: %v", err)
+	}
+	if len(quotas) != 0 {
+		t.Errorf("quotas = %v, want empty", quotas)
+	}
+	if next != 1 {
+		t.Errorf("nextProjectID = %d, want 1", next)
+	}
+}
+
+func TestScanExistingProjectIDsMissingDir(t *testing.T) {
+	quotas, next, err := scanExistingProjectIDs(t.TempDir() + "/does-not-exist")
+	if err != nil {
+		t.Fatalf("scanExistingProjectIDs: %v", err)
+	}
+	if len(quotas) != 0 || next != 1 {
+		t.Errorf("got quotas=%v next=%d, want empty map and 1", quotas, next)
+	}
+}
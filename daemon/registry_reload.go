@@ -0,0 +1,10 @@
+package daemon // import "github.com/moby/moby/v2/daemon"
+
+import "github.com/moby/moby/v2/registry"
+
+// ReloadRegistryConfig reloads the registry endpoint and auth configuration
+// from path into the resolver used by plugin and image pulls, so that a
+// SIGHUP picks up mirror and credential changes without a daemon restart.
+func ReloadRegistryConfig(path string) error {
+	return registry.ReloadDefault(path)
+}
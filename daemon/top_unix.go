@@ -6,9 +6,11 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"os/exec"
+	"os"
+	"os/user"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/moby/moby/api/types/container"
 	"github.com/moby/moby/api/types/events"
@@ -23,6 +25,13 @@ import (
 // See https://github.com/moby/moby/pull/24358
 var psArgsRegexp = lazyregexp.New("\\s+([^\\s]*)=\\s*(PID[^\\s]*)")
 
+// validatePSArgs rejects ps(1)-style argument strings that give a field
+// other than pid a header starting with "PID" (e.g. "-o user=PID"); a
+// "pid=..." header is allowed. Header overrides are otherwise ignored by
+// columnsFromFieldList.
+//
+// Deprecated: validatePSArgs only applies to the legacy PSArgs path; callers
+// using TopOptions.Columns skip it.
 func validatePSArgs(psArgs string) error {
 	for _, group := range psArgsRegexp.FindAllStringSubmatch(psArgs, -1) {
 		if len(group) >= 3 {
@@ -48,102 +57,502 @@ func fieldsASCII(s string) []string {
 	return strings.FieldsFunc(s, fn)
 }
 
-func appendProcess2ProcList(procList *container.TopResponse, fields []string) {
-	// Make sure number of fields equals number of header titles
-	// merging "overhanging" fields
-	process := fields[:len(procList.Titles)-1]
-	process = append(process, strings.Join(fields[len(procList.Titles)-1:], " "))
-	procList.Processes = append(procList.Processes, process)
+// defaultTopColumns mirrors the set of fields historically printed by
+// `ps -ef`, which is what ContainerTop used when called without arguments.
+// Note `ps -ef` prints PPID, not %CPU -- %CPU only shows up under the "aux"
+// column set below.
+var defaultTopColumns = []string{
+	container.TopColumnUser,
+	container.TopColumnPID,
+	container.TopColumnPPID,
+	container.TopColumnSTime,
+	container.TopColumnTTY,
+	container.TopColumnTime,
+	container.TopColumnCommand,
 }
 
-func hasPid(procs []uint32, pid int) bool {
-	for _, p := range procs {
-		if int(p) == pid {
-			return true
+// psFieldColumns maps the ps(1) field keywords accepted by -o/-eo/-O (see
+// the STANDARD FORMAT SPECIFIERS section of ps(1)) to their equivalent
+// structured column.
+var psFieldColumns = map[string]string{
+	"user":    container.TopColumnUser,
+	"uname":   container.TopColumnUser,
+	"pid":     container.TopColumnPID,
+	"ppid":    container.TopColumnPPID,
+	"pcpu":    container.TopColumnPCPU,
+	"%cpu":    container.TopColumnPCPU,
+	"pmem":    container.TopColumnPMem,
+	"%mem":    container.TopColumnPMem,
+	"stime":   container.TopColumnSTime,
+	"start":   container.TopColumnSTime,
+	"tty":     container.TopColumnTTY,
+	"tname":   container.TopColumnTTY,
+	"time":    container.TopColumnTime,
+	"cputime": container.TopColumnTime,
+	"comm":    container.TopColumnCommand,
+	"args":    container.TopColumnCommand,
+	"cmd":     container.TopColumnCommand,
+	"command": container.TopColumnCommand,
+	"wchan":   container.TopColumnWChan,
+	"rss":     container.TopColumnRSS,
+	"state":   container.TopColumnState,
+	"stat":    container.TopColumnState,
+}
+
+// columnsFromFieldList parses a comma-separated ps(1) -o/-eo/-O field list
+// (each field optionally suffixed with "=header", which is ignored --
+// Titles always uses the structured column name) into a Columns set. It
+// returns ok == false if any field isn't one columnsFromPSArgs knows how to
+// translate, so the caller can fall back instead of silently dropping or
+// misrendering a column the request asked for.
+func columnsFromFieldList(fieldList string) (columns []string, ok bool) {
+	fields := strings.Split(fieldList, ",")
+	columns = make([]string, 0, len(fields))
+	for _, f := range fields {
+		f = strings.TrimSpace(f)
+		if eq := strings.IndexByte(f, '='); eq >= 0 {
+			f = f[:eq]
+		}
+		col, known := psFieldColumns[strings.ToLower(f)]
+		if !known {
+			return nil, false
 		}
+		columns = append(columns, col)
 	}
-	return false
+	return columns, true
 }
 
-func parsePSOutput(output []byte, procs []uint32) (*container.TopResponse, error) {
-	procList := &container.TopResponse{}
+// psFieldListPrefixes are the ps(1) option spellings that introduce a
+// comma-separated field list, in the order columnsFromPSArgs checks them.
+var psFieldListPrefixes = []string{"-eo ", "-o ", "-O ", "eo "}
 
-	lines := strings.Split(string(output), "\n")
-	procList.Titles = fieldsASCII(lines[0])
+// columnsFromPSArgs maps a legacy ps(1)-style argument string to an
+// equivalent set of structured columns, so that old clients (and the
+// "psArgs" field of TopOptions) keep working against the native /proc
+// implementation.
+func columnsFromPSArgs(psArgs string) []string {
+	args := strings.TrimSpace(psArgs)
+	switch args {
+	case "", "-ef":
+		return defaultTopColumns
+	case "aux", "-aux", "au":
+		return []string{
+			container.TopColumnUser,
+			container.TopColumnPID,
+			container.TopColumnPCPU,
+			container.TopColumnPMem,
+			container.TopColumnSTime,
+			container.TopColumnTTY,
+			container.TopColumnState,
+			container.TopColumnTime,
+			container.TopColumnCommand,
+		}
+	}
 
-	pidIndex := -1
-	for i, name := range procList.Titles {
-		if name == "PID" {
-			pidIndex = i
-			break
+	for _, prefix := range psFieldListPrefixes {
+		rest, hasPrefix := strings.CutPrefix(args, prefix)
+		if !hasPrefix {
+			continue
 		}
+		if columns, ok := columnsFromFieldList(rest); ok {
+			return columns
+		}
+		break
 	}
-	if pidIndex == -1 {
-		return nil, errors.New("Couldn't find PID field in ps output")
+
+	// Anything more exotic (BSD-style option bundles, field keywords this
+	// translation doesn't recognize, and so on) can't be faithfully
+	// translated without re-introducing a full ps(1)-compatible
+	// format-string parser, so fall back to the default column set rather
+	// than rejecting the request.
+	return defaultTopColumns
+}
+
+// clockTicksPerSecond is USER_HZ, the unit /proc/<pid>/stat reports process
+// times in. It's configurable at kernel build time via CONFIG_HZ, but every
+// architecture Docker supports reports 100 here; runc and containerd make
+// the same assumption rather than paying for a cgo sysconf(_SC_CLK_TCK) call.
+const clockTicksPerSecond = 100
+
+// cpuSampleInterval is how long ContainerTop samples jiffies over in order to
+// compute %CPU. A real sample is required because /proc/<pid>/stat only
+// exposes cumulative CPU time, not an instantaneous rate.
+const cpuSampleInterval = 100 * time.Millisecond
+
+// procStat is the subset of /proc/<pid>/stat that ContainerTop cares about.
+type procStat struct {
+	comm      string
+	state     string
+	ppid      uint32
+	ttyNr     uint64
+	utime     uint64
+	stime     uint64
+	starttime uint64
+}
+
+func readProcStat(pid uint32) (*procStat, error) {
+	raw, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+	return parseProcStat(raw, pid)
+}
+
+// parseProcStat is the pure parsing half of readProcStat, split out so it
+// can be tested against fixed /proc/<pid>/stat content rather than the
+// real, non-deterministic /proc filesystem.
+func parseProcStat(raw []byte, pid uint32) (*procStat, error) {
+	s := string(raw)
+
+	// comm is the only field that can itself contain whitespace or
+	// parentheses, so locate it by its enclosing parens rather than by
+	// splitting on whitespace.
+	open := strings.IndexByte(s, '(')
+	close := strings.LastIndexByte(s, ')')
+	if open < 0 || close < open {
+		return nil, fmt.Errorf("unexpected format for pid %d stat", pid)
 	}
+	comm := s[open+1 : close]
 
-	// loop through the output and extract the PID from each line
-	// fixing #30580, be able to display thread line also when "m" option used
-	// in "docker top" client command
-	preContainedPidFlag := false
-	for _, line := range lines[1:] {
-		if line == "" {
+	// Fields after comm, 0-indexed starting from state (field 3 in the
+	// proc(5) numbering).
+	fields := fieldsASCII(s[close+1:])
+	const (
+		idxState     = 0
+		idxPPID      = 1
+		idxTTYNr     = 4
+		idxUtime     = 11
+		idxStime     = 12
+		idxStarttime = 19
+	)
+	if len(fields) <= idxStarttime {
+		return nil, fmt.Errorf("unexpected field count for pid %d stat", pid)
+	}
+	ppid, err := strconv.ParseUint(fields[idxPPID], 10, 32)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing ppid for pid %d", pid)
+	}
+	ttyNr, err := strconv.ParseUint(fields[idxTTYNr], 10, 64)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing tty_nr for pid %d", pid)
+	}
+	utime, err := strconv.ParseUint(fields[idxUtime], 10, 64)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing utime for pid %d", pid)
+	}
+	stime, err := strconv.ParseUint(fields[idxStime], 10, 64)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing stime for pid %d", pid)
+	}
+	starttime, err := strconv.ParseUint(fields[idxStarttime], 10, 64)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing starttime for pid %d", pid)
+	}
+	return &procStat{
+		comm:      comm,
+		state:     fields[idxState],
+		ppid:      uint32(ppid),
+		ttyNr:     ttyNr,
+		utime:     utime,
+		stime:     stime,
+		starttime: starttime,
+	}, nil
+}
+
+func readProcCmdline(pid uint32) string {
+	raw, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil || len(raw) == 0 {
+		return ""
+	}
+	args := bytes.Split(bytes.TrimRight(raw, "\x00"), []byte{0})
+	parts := make([]string, 0, len(args))
+	for _, a := range args {
+		parts = append(parts, string(a))
+	}
+	return strings.Join(parts, " ")
+}
+
+func readProcWchan(pid uint32) string {
+	raw, err := os.ReadFile(fmt.Sprintf("/proc/%d/wchan", pid))
+	if err != nil || len(raw) == 0 {
+		return "-"
+	}
+	return string(raw)
+}
+
+func readProcLoginuid(pid uint32) string {
+	raw, err := os.ReadFile(fmt.Sprintf("/proc/%d/loginuid", pid))
+	if err != nil {
+		return "-"
+	}
+	uid := strings.TrimSpace(string(raw))
+	// The kernel reports 4294967295 (-1 as uint32) when no loginuid has
+	// been set for the process.
+	if uid == "" || uid == "4294967295" {
+		return "-"
+	}
+	return uid
+}
+
+// readProcRSSKB reads VmRSS, in kilobytes, from /proc/<pid>/status.
+func readProcRSSKB(pid uint32) (uint64, error) {
+	raw, err := os.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(raw), "\n") {
+		if !strings.HasPrefix(line, "VmRSS:") {
 			continue
 		}
-		fields := fieldsASCII(line)
+		fields := fieldsASCII(strings.TrimPrefix(line, "VmRSS:"))
+		if len(fields) == 0 {
+			return 0, nil
+		}
+		return strconv.ParseUint(fields[0], 10, 64)
+	}
+	return 0, nil
+}
 
-		var (
-			p   int
-			err error
-		)
+// usernameForUID resolves a numeric UID to a username, falling back to the
+// numeric value when it cannot be resolved (e.g. the container's rootfs uses
+// a /etc/passwd the daemon has no visibility into).
+func usernameForUID(uid uint32) string {
+	u, err := user.LookupId(strconv.FormatUint(uint64(uid), 10))
+	if err != nil {
+		return strconv.FormatUint(uint64(uid), 10)
+	}
+	return u.Username
+}
 
-		if fields[pidIndex] == "-" {
-			if preContainedPidFlag {
-				appendProcess2ProcList(procList, fields)
-			}
+func startTimeForPID(stat *procStat, bootTime time.Time) string {
+	t := bootTime.Add(time.Duration(stat.starttime) * time.Second / clockTicksPerSecond)
+	if time.Since(t) < 24*time.Hour {
+		return t.Format("15:04")
+	}
+	return t.Format("Jan02")
+}
+
+func formatCPUTime(stat *procStat) string {
+	total := (stat.utime + stat.stime) / clockTicksPerSecond
+	return fmt.Sprintf("%02d:%02d:%02d", total/3600, (total/60)%60, total%60)
+}
+
+// readBootTime returns the host's boot time, used to translate a process's
+// /proc/<pid>/stat starttime (in jiffies since boot) into a wall-clock time.
+func readBootTime() (time.Time, error) {
+	raw, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, line := range strings.Split(string(raw), "\n") {
+		if !strings.HasPrefix(line, "btime ") {
 			continue
 		}
-		p, err = strconv.Atoi(fields[pidIndex])
+		sec, err := strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(line, "btime ")), 10, 64)
 		if err != nil {
-			return nil, fmt.Errorf("Unexpected pid '%s': %s", fields[pidIndex], err)
+			return time.Time{}, err
 		}
+		return time.Unix(sec, 0), nil
+	}
+	return time.Time{}, errors.New("btime not found in /proc/stat")
+}
+
+// procSnapshot holds the data needed to render a single process's row,
+// including the two jiffy samples CPU% is computed from.
+type procSnapshot struct {
+	pid         uint32
+	stat        *procStat
+	sampledStat *procStat // re-read after cpuSampleInterval, nil if the process exited meanwhile
+}
+
+func pidsTitle(columns []string) []string {
+	titles := make([]string, len(columns))
+	copy(titles, columns)
+	return titles
+}
 
-		if hasPid(procs, p) {
-			preContainedPidFlag = true
-			appendProcess2ProcList(procList, fields)
+func (daemon *Daemon) readNativeTop(procs []uint32, columns []string) (*container.TopResponse, error) {
+	bootTime, err := readBootTime()
+	if err != nil {
+		return nil, errdefs.System(errors.Wrap(err, "reading boot time"))
+	}
+
+	snapshots := make([]*procSnapshot, 0, len(procs))
+	for _, pid := range procs {
+		stat, err := readProcStat(pid)
+		if err != nil {
+			// The process may have exited between Pids() and now; skip it
+			// rather than failing the whole request.
 			continue
 		}
-		preContainedPidFlag = false
+		snapshots = append(snapshots, &procSnapshot{pid: pid, stat: stat})
+	}
+
+	needsCPU := false
+	for _, c := range columns {
+		if c == container.TopColumnPCPU {
+			needsCPU = true
+			break
+		}
+	}
+	if needsCPU {
+		time.Sleep(cpuSampleInterval)
+		for _, snap := range snapshots {
+			if stat, err := readProcStat(snap.pid); err == nil {
+				snap.sampledStat = stat
+			}
+		}
+	}
+
+	procList := &container.TopResponse{Titles: pidsTitle(columns)}
+	for _, snap := range snapshots {
+		row := make([]string, 0, len(columns))
+		for _, c := range columns {
+			switch c {
+			case container.TopColumnPID:
+				row = append(row, strconv.FormatUint(uint64(snap.pid), 10))
+			case container.TopColumnPPID:
+				row = append(row, strconv.FormatUint(uint64(snap.stat.ppid), 10))
+			case container.TopColumnUser:
+				row = append(row, usernameForUID(ownerUID(snap.pid)))
+			case container.TopColumnPCPU:
+				row = append(row, formatCPUPercent(snap))
+			case container.TopColumnPMem:
+				row = append(row, formatMemPercent(snap.pid))
+			case container.TopColumnSTime:
+				row = append(row, startTimeForPID(snap.stat, bootTime))
+			case container.TopColumnTime:
+				row = append(row, formatCPUTime(snap.stat))
+			case container.TopColumnCommand:
+				if cmd := readProcCmdline(snap.pid); cmd != "" {
+					row = append(row, cmd)
+				} else {
+					row = append(row, snap.stat.comm)
+				}
+			case container.TopColumnTTY:
+				row = append(row, formatTTY(snap.stat.ttyNr))
+			case container.TopColumnWChan:
+				row = append(row, readProcWchan(snap.pid))
+			case container.TopColumnRSS:
+				rss, _ := readProcRSSKB(snap.pid)
+				row = append(row, strconv.FormatUint(rss, 10))
+			case container.TopColumnState:
+				row = append(row, snap.stat.state)
+			default:
+				row = append(row, "-")
+			}
+		}
+		procList.Processes = append(procList.Processes, row)
 	}
 	return procList, nil
 }
 
-// psPidsArg converts a slice of PIDs to a string consisting
-// of comma-separated list of PIDs prepended by "-q".
-// For example, psPidsArg([]uint32{1,2,3}) returns "-q1,2,3".
-func psPidsArg(pids []uint32) string {
-	b := []byte{'-', 'q'}
-	for i, p := range pids {
-		b = strconv.AppendUint(b, uint64(p), 10)
-		if i < len(pids)-1 {
-			b = append(b, ',')
+func formatCPUPercent(snap *procSnapshot) string {
+	if snap.sampledStat == nil {
+		return "0.0"
+	}
+	deltaTicks := (snap.sampledStat.utime + snap.sampledStat.stime) - (snap.stat.utime + snap.stat.stime)
+	pct := float64(deltaTicks) / clockTicksPerSecond / cpuSampleInterval.Seconds() * 100
+	return fmt.Sprintf("%.1f", pct)
+}
+
+func formatMemPercent(pid uint32) string {
+	rssKB, err := readProcRSSKB(pid)
+	if err != nil {
+		return "0.0"
+	}
+	totalKB, err := readMemTotalKB()
+	if err != nil || totalKB == 0 {
+		return "0.0"
+	}
+	return fmt.Sprintf("%.1f", float64(rssKB)/float64(totalKB)*100)
+}
+
+func readMemTotalKB() (uint64, error) {
+	raw, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(raw), "\n") {
+		if !strings.HasPrefix(line, "MemTotal:") {
+			continue
 		}
+		fields := fieldsASCII(strings.TrimPrefix(line, "MemTotal:"))
+		if len(fields) == 0 {
+			return 0, nil
+		}
+		return strconv.ParseUint(fields[0], 10, 64)
 	}
-	return string(b)
+	return 0, nil
 }
 
-// ContainerTop lists the processes running inside of the given
-// container by calling ps with the given args, or with the flags
-// "-ef" if no args are given.  An error is returned if the container
-// is not found, or is not running, or if there are any problems
-// running ps, or parsing the output.
-func (daemon *Daemon) ContainerTop(name string, psArgs string) (*container.TopResponse, error) {
-	if psArgs == "" {
-		psArgs = "-ef"
+// formatTTY renders a tty_nr (as packed by the kernel: major in the high
+// bits, minor in the low bits) the way ps(1) does, or "?" when the process
+// has no controlling terminal.
+func formatTTY(ttyNr uint64) string {
+	if ttyNr == 0 {
+		return "?"
 	}
+	major := (ttyNr >> 8) & 0xfff
+	minor := (ttyNr & 0xff) | ((ttyNr >> 20) << 8)
+	switch major {
+	case 4:
+		return fmt.Sprintf("tty%d", minor)
+	case 136:
+		return fmt.Sprintf("pts/%d", minor)
+	default:
+		return "?"
+	}
+}
 
-	if err := validatePSArgs(psArgs); err != nil {
-		return nil, err
+// ownerUID reads the real UID from /proc/<pid>/status.
+func ownerUID(pid uint32) uint32 {
+	raw, err := os.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(raw), "\n") {
+		if !strings.HasPrefix(line, "Uid:") {
+			continue
+		}
+		fields := fieldsASCII(strings.TrimPrefix(line, "Uid:"))
+		if len(fields) == 0 {
+			return 0
+		}
+		uid, _ := strconv.ParseUint(fields[0], 10, 32)
+		return uint32(uid)
+	}
+	return 0
+}
+
+// ContainerTop lists the processes running inside of the given container.
+// It reads process information natively from /proc for the PIDs returned by
+// the container's task, so it has no dependency on a host ps(1) binary. An
+// error is returned if the container is not found, or is not running, or if
+// the host's boot time cannot be read from /proc/stat. A process whose
+// /proc/<pid>/stat cannot be read or parsed is left out of the result.
+func (daemon *Daemon) ContainerTop(name string, psArgs string) (*container.TopResponse, error) {
+	return daemon.containerTop(name, container.TopOptions{PSArgs: psArgs})
+}
+
+// ContainerTopWithOptions is the structured counterpart of ContainerTop,
+// accepting a TopOptions so that callers which have already resolved a
+// Columns set (e.g. a future API version, or an internal caller that wants
+// specific columns) aren't forced through the PSArgs translation round-trip.
+func (daemon *Daemon) ContainerTopWithOptions(name string, opts container.TopOptions) (*container.TopResponse, error) {
+	return daemon.containerTop(name, opts)
+}
+
+// containerTop is the structured counterpart of ContainerTop, accepting a
+// TopOptions so that callers which have already resolved a column set (e.g.
+// the API router) don't pay the PSArgs translation round-trip.
+func (daemon *Daemon) containerTop(name string, opts container.TopOptions) (*container.TopResponse, error) {
+	columns := opts.Columns
+	if len(columns) == 0 {
+		if err := validatePSArgs(opts.PSArgs); err != nil {
+			return nil, err
+		}
+		columns = columnsFromPSArgs(opts.PSArgs)
 	}
 
 	ctr, err := daemon.GetContainer(name)
@@ -177,25 +586,7 @@ func (daemon *Daemon) ContainerTop(name string, psArgs string) (*container.TopRe
 		procs[i] = p.Pid
 	}
 
-	args := strings.Split(psArgs, " ")
-	pids := psPidsArg(procs)
-	output, err := exec.Command("ps", append(args, pids)...).Output()
-	if err != nil {
-		// some ps options (such as f) can't be used together with q,
-		// so retry without it
-		output, err = exec.Command("ps", args...).Output()
-		if err != nil {
-			if ee, ok := err.(*exec.ExitError); ok {
-				// first line of stderr shows why ps failed
-				line := bytes.SplitN(ee.Stderr, []byte{'\n'}, 2)
-				if len(line) > 0 && len(line[0]) > 0 {
-					err = errors.New(string(line[0]))
-				}
-			}
-			return nil, errdefs.System(errors.Wrap(err, "ps"))
-		}
-	}
-	procList, err := parsePSOutput(output, procs)
+	procList, err := daemon.readNativeTop(procs, columns)
 	if err != nil {
 		return nil, err
 	}
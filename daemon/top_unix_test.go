@@ -0,0 +1,116 @@
+//go:build !windows
+
+package daemon
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/moby/moby/api/types/container"
+	"gotest.tools/v3/assert"
+)
+
+func TestColumnsFromPSArgs(t *testing.T) {
+	tests := []struct {
+		doc     string
+		psArgs  string
+		want    []string
+		wantDef bool // want == defaultTopColumns
+	}{
+		{doc: "empty defaults to -ef", psArgs: "", wantDef: true},
+		{doc: "-ef", psArgs: "-ef", wantDef: true},
+		{
+			doc:    "aux",
+			psArgs: "aux",
+			want: []string{
+				container.TopColumnUser,
+				container.TopColumnPID,
+				container.TopColumnPCPU,
+				container.TopColumnPMem,
+				container.TopColumnSTime,
+				container.TopColumnTTY,
+				container.TopColumnState,
+				container.TopColumnTime,
+				container.TopColumnCommand,
+			},
+		},
+		{
+			doc:    "-eo field list",
+			psArgs: "-eo pid,user",
+			want:   []string{container.TopColumnPID, container.TopColumnUser},
+		},
+		{
+			doc:    "-o field list",
+			psArgs: "-o pid,ppid,%cpu",
+			want:   []string{container.TopColumnPID, container.TopColumnPPID, container.TopColumnPCPU},
+		},
+		{
+			doc:    "field list with header overrides",
+			psArgs: "-eo pid=PID,comm=COMMAND",
+			want:   []string{container.TopColumnPID, container.TopColumnCommand},
+		},
+		{doc: "unrecognized field falls back to default", psArgs: "-eo pid,bogus", wantDef: true},
+		{doc: "unrecognized format falls back to default", psArgs: "-gu www", wantDef: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.doc, func(t *testing.T) {
+			want := tc.want
+			if tc.wantDef {
+				want = defaultTopColumns
+			}
+			assert.DeepEqual(t, columnsFromPSArgs(tc.psArgs), want)
+		})
+	}
+}
+
+func TestFormatTTY(t *testing.T) {
+	tests := []struct {
+		ttyNr uint64
+		want  string
+	}{
+		{ttyNr: 0, want: "?"},
+		{ttyNr: 4<<8 | 1, want: "tty1"},
+		{ttyNr: 136<<8 | 5, want: "pts/5"},
+		{ttyNr: 200<<8 | 1, want: "?"},
+	}
+	for _, tc := range tests {
+		t.Run(fmt.Sprintf("ttyNr=%d", tc.ttyNr), func(t *testing.T) {
+			assert.Equal(t, formatTTY(tc.ttyNr), tc.want)
+		})
+	}
+}
+
+func TestFormatCPUPercent(t *testing.T) {
+	base := &procStat{utime: 100, stime: 100}
+
+	// No second sample yet (process may have exited mid-sample): 0%.
+	assert.Equal(t, formatCPUPercent(&procSnapshot{stat: base}), "0.0")
+
+	// clockTicksPerSecond ticks (one second of CPU time) accrued over
+	// cpuSampleInterval (100ms) is ten times the interval, i.e. 1000%.
+	sampled := &procStat{utime: base.utime + clockTicksPerSecond, stime: base.stime}
+	assert.Equal(t, formatCPUPercent(&procSnapshot{stat: base, sampledStat: sampled}), "1000.0")
+}
+
+func TestParseProcStat(t *testing.T) {
+	// A comm containing a space and parens, as real processes can have
+	// (e.g. "(sd-pam)"), to exercise the paren-matching parse.
+	stat := "1234 (my (odd) proc) S 1 1234 1234 0 -1 4194560 100 0 0 0 5 7 0 0 20 0 1 0 9999 0 0 18446744073709551615 0 0 0 0 0 0 0 0 0 0 0 0 17 1 0 0 0 0 0\n"
+
+	got, err := parseProcStat([]byte(stat), 1234)
+	assert.NilError(t, err)
+	assert.Equal(t, got.comm, "my (odd) proc")
+	assert.Equal(t, got.state, "S")
+	assert.Equal(t, got.ppid, uint32(1))
+	assert.Equal(t, got.utime, uint64(5))
+	assert.Equal(t, got.stime, uint64(7))
+	assert.Equal(t, got.starttime, uint64(9999))
+}
+
+func TestParseProcStatMalformed(t *testing.T) {
+	_, err := parseProcStat([]byte("not a valid stat line"), 1)
+	assert.ErrorContains(t, err, "unexpected format")
+
+	_, err = parseProcStat([]byte("1 (x) S 1 1\n"), 1)
+	assert.ErrorContains(t, err, "unexpected field count")
+}
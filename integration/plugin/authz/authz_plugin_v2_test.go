@@ -0,0 +1,218 @@
+package authz // import "github.com/moby/moby/v2/integration/plugin/authz"
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/client"
+	"github.com/moby/moby/v2/testutil"
+	"github.com/moby/moby/v2/testutil/daemon"
+	"github.com/moby/moby/v2/testutil/environment"
+	"github.com/moby/moby/v2/testutil/registry"
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/skip"
+)
+
+// pluginV2AuthzRepo is the repository the fixture plugin is pushed to on the
+// throwaway local registry TestAuthzPluginV2AllowDeny starts, so that
+// `docker plugin install` has something real to pull from -- there is no
+// registry to push a managed plugin to otherwise.
+const pluginV2AuthzRepo = "authz-plugin-v2-test"
+
+func skipIfNotLinux(t *testing.T) {
+	t.Helper()
+	skip.If(t, testEnv.DaemonInfo.OSType != "linux", "managed plugins are linux-only")
+}
+
+// TestAuthzPluginV2AllowDeny builds the fixture plugin, pushes it to a local
+// registry, and installs it with `docker plugin install` for both the allow
+// and deny cases, exercising the managed-plugin install -> enable -> authz
+// path against a real daemon end-to-end through the gRPC transport.
+//
+// A v2 authz plugin needs no `--authorization-plugin` daemon flag: per
+// [pluginv2.Registry], enabling a plugin whose manifest declares the
+// authzv2 interface registers it automatically.
+func TestAuthzPluginV2AllowDeny(t *testing.T) {
+	skipIfNotLinux(t)
+	ctx := testutil.StartSpan(baseContext(t), t)
+
+	reg := registry.NewV2(t)
+	defer reg.Close()
+
+	d := daemon.New(t)
+	d.StartWithBusybox(ctx, t)
+	defer d.Stop(t)
+
+	apiClient := d.NewClientT(t)
+	defer apiClient.Close()
+
+	ref := reg.URL() + "/" + pluginV2AuthzRepo
+	pushFixturePlugin(ctx, t, apiClient, ref)
+
+	t.Run("allow", func(t *testing.T) {
+		name := installAuthzPlugin(ctx, t, apiClient, ref, true)
+		defer apiClient.PluginRemove(ctx, name, client.PluginRemoveOptions{Force: true})
+
+		_, err := apiClient.ContainerList(ctx, client.ContainerListOptions{})
+		assert.NilError(t, err)
+	})
+
+	t.Run("deny", func(t *testing.T) {
+		name := installAuthzPlugin(ctx, t, apiClient, ref, false)
+		defer apiClient.PluginRemove(ctx, name, client.PluginRemoveOptions{Force: true})
+
+		_, err := apiClient.ContainerList(ctx, client.ContainerListOptions{})
+		assert.ErrorContains(t, err, "authorization denied")
+	})
+}
+
+// TestAuthzPluginV2BadManifest asserts that installing a plugin with a
+// manifest that doesn't declare the authz interface is rejected, rather than
+// silently being treated as a no-op authorizer.
+func TestAuthzPluginV2BadManifest(t *testing.T) {
+	skipIfNotLinux(t)
+	ctx := testutil.StartSpan(baseContext(t), t)
+
+	d := daemon.New(t)
+	d.StartWithBusybox(ctx, t)
+	defer d.Stop(t)
+
+	apiClient := d.NewClientT(t)
+	defer apiClient.Close()
+
+	err := apiClient.PluginInstall(ctx, "moby-authz-plugin-bad-manifest", client.PluginInstallOptions{
+		Disabled:             true,
+		AcceptAllPermissions: true,
+	})
+	assert.ErrorContains(t, err, "manifest")
+}
+
+// TestAuthzPluginV2NonexistentPlugin asserts that enabling an authorization
+// plugin that was never installed fails daemon startup/reload cleanly
+// instead of silently authorizing every request.
+func TestAuthzPluginV2NonexistentPlugin(t *testing.T) {
+	skipIfNotLinux(t)
+	ctx := testutil.StartSpan(baseContext(t), t)
+
+	d := daemon.New(t)
+	err := d.StartWithError("--authorization-plugin=moby-authz-plugin-does-not-exist")
+	assert.ErrorContains(t, err, "plugin")
+	if err == nil {
+		d.Stop(t)
+	}
+}
+
+func baseContext(t *testing.T) context.Context {
+	t.Helper()
+	return context.Background()
+}
+
+// pushFixturePlugin builds the plugin rootfs under ./fixtures/v2plugin,
+// creates it locally as a managed plugin, and pushes it to ref so that
+// installAuthzPlugin below has a real reference to pull with `docker plugin
+// install`.
+func pushFixturePlugin(ctx context.Context, t *testing.T, apiClient client.APIClient, ref string) {
+	t.Helper()
+
+	// The fixture Dockerfile builds a static Go binary that implements the
+	// authzv2.AuthZPlugin gRPC service over a unix socket; build it as a
+	// regular image first so its rootfs can be exported into the plugin
+	// bundle PluginCreate expects (config.json at the bundle root, plus a
+	// rootfs/ directory).
+	const buildImage = "authz-plugin-v2-build"
+	environment.BuildImage(ctx, t, apiClient, "./fixtures/v2plugin", buildImage)
+
+	ctr, err := apiClient.ContainerCreate(ctx, &container.Config{Image: buildImage}, nil, nil, nil, "")
+	assert.NilError(t, err)
+	defer apiClient.ContainerRemove(ctx, ctr.ID, client.ContainerRemoveOptions{Force: true})
+
+	rootfs, err := apiClient.ContainerExport(ctx, ctr.ID)
+	assert.NilError(t, err)
+	defer rootfs.Close()
+
+	bundle, err := pluginBundle(rootfs)
+	assert.NilError(t, err)
+
+	assert.NilError(t, apiClient.PluginCreate(ctx, ref, bundle))
+	assert.NilError(t, apiClient.PluginPush(ctx, ref, ""))
+	assert.NilError(t, apiClient.PluginRemove(ctx, ref, client.PluginRemoveOptions{Force: true}))
+}
+
+// readFixtureConfig reads the plugin manifest checked into
+// ./fixtures/v2plugin/config.json, declaring the authzv2 interface and the
+// AUTHZ_PLUGIN_V2_ALLOW config arg.
+func readFixtureConfig() ([]byte, error) {
+	return os.ReadFile("./fixtures/v2plugin/config.json")
+}
+
+// pluginBundle re-tars a container's exported filesystem (rootfs) under
+// rootfs/, alongside fixtures/v2plugin/config.json at the bundle root, in
+// the layout PluginCreate requires.
+func pluginBundle(rootfs io.Reader) (io.Reader, error) {
+	config, err := readFixtureConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Name: "config.json", Mode: 0o644, Size: int64(len(config))}); err != nil {
+		return nil, err
+	}
+	if _, err := tw.Write(config); err != nil {
+		return nil, err
+	}
+
+	tr := tar.NewReader(rootfs)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		hdr.Name = "rootfs/" + hdr.Name
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+// installAuthzPlugin installs a fresh local copy of the plugin pushed to
+// ref, named uniquely per allow/deny so the two subtests in
+// TestAuthzPluginV2AllowDeny don't fight over one plugin's enabled config,
+// driving the decision through the AUTHZ_PLUGIN_V2_ALLOW config arg
+// config.json declares -- the same "KEY=VALUE" install-time config `docker
+// plugin install PLUGIN KEY=VALUE` accepts on the CLI -- rather than any
+// daemon-side flag.
+func installAuthzPlugin(ctx context.Context, t *testing.T, apiClient client.APIClient, ref string, allow bool) string {
+	t.Helper()
+
+	value := "0"
+	name := ref + "-deny"
+	if allow {
+		value = "1"
+		name = ref + "-allow"
+	}
+
+	err := apiClient.PluginInstall(ctx, name, client.PluginInstallOptions{
+		RemoteRef:            ref,
+		AcceptAllPermissions: true,
+		Args:                 []string{"AUTHZ_PLUGIN_V2_ALLOW=" + value},
+	})
+	assert.NilError(t, err)
+	return name
+}
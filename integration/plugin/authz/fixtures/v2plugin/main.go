@@ -0,0 +1,68 @@
+// Command v2plugin is a minimal authzv2.AuthZPlugin implementation used as
+// a test fixture: it always returns the verdict baked in at build/run time
+// via AUTHZ_PLUGIN_V2_ALLOW ("1" to allow, anything else to deny), and
+// declares no optional capabilities.
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"os"
+
+	"github.com/moby/moby/v2/pkg/authorization/pluginv2/authzv2"
+	"google.golang.org/grpc"
+)
+
+const socketPath = "/run/docker/plugins/v2plugin.sock"
+
+type server struct {
+	authzv2.UnimplementedAuthZPluginServer
+	allow bool
+}
+
+func (s *server) Handshake(ctx context.Context, req *authzv2.HandshakeRequest) (*authzv2.HandshakeResponse, error) {
+	return &authzv2.HandshakeResponse{}, nil
+}
+
+func (s *server) verdict() *authzv2.AuthZResponse {
+	if s.allow {
+		return &authzv2.AuthZResponse{Allow: true}
+	}
+	return &authzv2.AuthZResponse{Allow: false, Msg: "denied by v2plugin fixture"}
+}
+
+func (s *server) AuthZRequest(stream authzv2.AuthZPlugin_AuthZRequestServer) error {
+	for {
+		if _, err := stream.Recv(); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	return stream.SendAndClose(s.verdict())
+}
+
+func (s *server) AuthZResponse(stream authzv2.AuthZPlugin_AuthZResponseServer) error {
+	for {
+		if _, err := stream.Recv(); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	return stream.SendAndClose(s.verdict())
+}
+
+func main() {
+	_ = os.MkdirAll("/run/docker/plugins", 0o755)
+	lis, err := net.Listen("unix", socketPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s := grpc.NewServer()
+	authzv2.RegisterAuthZPluginServer(s, &server{allow: os.Getenv("AUTHZ_PLUGIN_V2_ALLOW") == "1"})
+	log.Fatal(s.Serve(lis))
+}
@@ -0,0 +1,20 @@
+package authz // import "github.com/moby/moby/v2/integration/plugin/authz"
+
+import (
+	"context"
+	"testing"
+
+	"github.com/moby/moby/v2/testutil/environment"
+)
+
+var testEnv *environment.Execution
+
+func TestMain(m *testing.M) {
+	var err error
+	testEnv, err = environment.New(context.Background())
+	if err != nil {
+		panic(err)
+	}
+	testEnv.Print()
+	environment.Run(m, testEnv)
+}
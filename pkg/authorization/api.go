@@ -0,0 +1,77 @@
+package authorization
+
+import "crypto/x509"
+
+// PeerCertificate is a wrapper around x509.Certificate that provides a
+// JSON friendly representation, suitable for passing peer certificates to
+// authorization plugins.
+type PeerCertificate x509.Certificate
+
+// Request holds data required for authZ plugins
+type Request struct {
+	// User holds the user extracted by an AuthN mechanism
+	User string `json:"User,omitempty"`
+
+	// UserAuthNMethod holds the mechanism used to extract user details
+	// (e.g., krb5, ldap, jwt, tls)
+	UserAuthNMethod string `json:"UserAuthNMethod,omitempty"`
+
+	// RequestMethod holds the HTTP method (GET/POST/PUT)
+	RequestMethod string `json:"RequestMethod,omitempty"`
+
+	// RequestURI holds the full HTTP URI (e.g., /containers/json?all=1)
+	RequestURI string `json:"RequestUri,omitempty"`
+
+	// RequestBody stores the raw request body sent to the docker daemon.
+	// Empty when the body was delivered via the streaming path instead (see
+	// BodyStreamID), or dropped because it exceeded maxBodySize and no
+	// plugin requested the streaming path (see BodyTruncated).
+	RequestBody []byte `json:"RequestBody,omitempty"`
+
+	// RequestHeaders stores the request headers sent to the docker daemon
+	RequestHeaders map[string]string `json:"RequestHeaders,omitempty"`
+
+	// RequestPeerCertificates stores the request peer certificates
+	RequestPeerCertificates []*PeerCertificate `json:"RequestPeerCertificates,omitempty"`
+
+	// RequestClaims holds the claims extracted from the request's bearer
+	// token once it has been validated against the configured JWKS (see
+	// JWTValidator). Nil when the request was authenticated some other way
+	// (e.g. TLS client certificates) or UserAuthNMethod != "jwt". Plugins can
+	// use it to make decisions without re-parsing the (stripped) Authorization
+	// header themselves.
+	RequestClaims map[string]any `json:"RequestClaims,omitempty"`
+
+	// BodyStreamID is a random per-call identifier set on the Request handed
+	// to a StreamingPlugin's AuthZRequestStream/AuthZResponseStream, which
+	// receive the body itself as their io.Reader argument rather than in
+	// RequestBody/ResponseBody. Empty unless a plugin negotiated
+	// CapabilityStreamingBody and the body exceeds maxBodySize.
+	BodyStreamID string `json:"BodyStreamID,omitempty"`
+
+	// BodyTruncated is true when RequestBody/ResponseBody were omitted
+	// because the body exceeded maxBodySize and no plugin consuming this
+	// request advertised CapabilityStreamingBody.
+	BodyTruncated bool `json:"BodyTruncated,omitempty"`
+
+	// ResponseStatusCode stores the status code returned from docker daemon
+	ResponseStatusCode int `json:"ResponseStatusCode,omitempty"`
+
+	// ResponseBody stores the response body sent from docker daemon
+	ResponseBody []byte `json:"ResponseBody,omitempty"`
+
+	// ResponseHeaders stores the response headers sent to the docker daemon
+	ResponseHeaders map[string]string `json:"ResponseHeaders,omitempty"`
+}
+
+// Response represents authZ plugin response
+type Response struct {
+	// Allow indicating if the request should be allowed
+	Allow bool `json:"Allow"`
+
+	// Msg stores the authorization message
+	Msg string `json:"Msg,omitempty"`
+
+	// Err stores a message in case there's an error
+	Err string `json:"Err,omitempty"`
+}
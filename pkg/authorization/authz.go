@@ -14,6 +14,7 @@ import (
 
 	"github.com/containerd/log"
 	"github.com/moby/moby/v2/pkg/ioutils"
+	"github.com/moby/moby/v2/pkg/stringid"
 )
 
 const maxBodySize = 1048576 // 1MB
@@ -44,6 +45,16 @@ func NewCtx(authZPlugins []Plugin, user, userAuthNMethod, requestMethod, request
 	}
 }
 
+// NewCtxWithClaims is like NewCtx, but additionally attaches claims resolved
+// from a validated bearer token (userAuthNMethod should be "jwt" in this
+// case; see JWTValidator). Plugins receive claims via Request.RequestClaims
+// instead of having to re-parse the Authorization header.
+func NewCtxWithClaims(authZPlugins []Plugin, user, userAuthNMethod, requestMethod, requestURI string, claims map[string]any) *Ctx {
+	ctx := NewCtx(authZPlugins, user, userAuthNMethod, requestMethod, requestURI)
+	ctx.claims = claims
+	return ctx
+}
+
 // Ctx stores a single request-response interaction context
 type Ctx struct {
 	user            string
@@ -51,6 +62,8 @@ type Ctx struct {
 	requestMethod   string
 	requestURI      string
 	plugins         []Plugin
+	// claims holds the bearer-token claims resolved for this request, if any
+	claims map[string]any
 	// authReq stores the cached request object for the current transaction
 	authReq *Request
 }
@@ -70,10 +83,15 @@ func isChunked(r *http.Request) bool {
 
 // AuthZRequest authorized the request to the docker daemon using authZ plugins
 func (ctx *Ctx) AuthZRequest(w http.ResponseWriter, r *http.Request) error {
-	var body []byte
-	if sendBody(ctx.requestURI, r.Header) && (r.ContentLength > 0 || isChunked(r)) && r.ContentLength < maxBodySize {
+	hasBody := sendBody(ctx.requestURI, r.Header) && (r.ContentLength > 0 || isChunked(r))
+
+	var (
+		body      []byte
+		oversized bool
+	)
+	if hasBody {
 		var err error
-		body, r.Body, err = drainBody(r.Body)
+		body, oversized, r.Body, err = peekBody(r.Body)
 		if err != nil {
 			return err
 		}
@@ -91,6 +109,8 @@ func (ctx *Ctx) AuthZRequest(w http.ResponseWriter, r *http.Request) error {
 		RequestURI:      ctx.requestURI,
 		RequestBody:     body,
 		RequestHeaders:  headers(r.Header),
+		RequestClaims:   ctx.claims,
+		BodyTruncated:   oversized,
 	}
 
 	if r.TLS != nil {
@@ -103,7 +123,15 @@ func (ctx *Ctx) AuthZRequest(w http.ResponseWriter, r *http.Request) error {
 	for _, plugin := range ctx.plugins {
 		log.G(context.TODO()).Debugf("AuthZ request using plugin %s", plugin.Name())
 
-		authRes, err := plugin.AuthZRequest(ctx.authReq)
+		var (
+			authRes *Response
+			err     error
+		)
+		if oversized {
+			authRes, err = ctx.authZRequestStreamed(plugin, r)
+		} else {
+			authRes, err = plugin.AuthZRequest(ctx.authReq)
+		}
 		if err != nil {
 			return fmt.Errorf("plugin %s failed with error: %s", plugin.Name(), err)
 		}
@@ -116,18 +144,62 @@ func (ctx *Ctx) AuthZRequest(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+// authZRequestStreamed hands an oversized body to plugin's streaming path
+// when it has negotiated CapabilityStreamingBody, falling back to the
+// regular buffered Plugin.AuthZRequest (with an empty, BodyTruncated
+// request body) otherwise.
+//
+// Whatever prefix of the body the plugin reads is captured via a TeeReader
+// and replayed ahead of the remainder of r.Body once the plugin returns, so
+// that the daemon still sees the full, untouched body afterwards.
+func (ctx *Ctx) authZRequestStreamed(plugin Plugin, r *http.Request) (*Response, error) {
+	sp, ok := asStreamingPlugin(plugin)
+	if !ok {
+		return plugin.AuthZRequest(ctx.authReq)
+	}
+
+	var peeked bytes.Buffer
+	streamReq := *ctx.authReq
+	streamReq.BodyStreamID = stringid.GenerateRandomID()
+	streamReq.BodyTruncated = false
+
+	authRes, err := sp.AuthZRequestStream(&streamReq, io.TeeReader(r.Body, &peeked))
+	if peeked.Len() > 0 {
+		r.Body = ioutils.NewReadCloserWrapper(io.MultiReader(bytes.NewReader(peeked.Bytes()), r.Body), r.Body.Close)
+	}
+	return authRes, err
+}
+
 // AuthZResponse authorized and manipulates the response from docker daemon using authZ plugins
 func (ctx *Ctx) AuthZResponse(rm ResponseModifier, r *http.Request) error {
 	ctx.authReq.ResponseStatusCode = rm.StatusCode()
 	ctx.authReq.ResponseHeaders = headers(rm.Header())
 
-	if sendBody(ctx.requestURI, rm.Header()) {
-		ctx.authReq.ResponseBody = rm.RawBody()
+	rawBody := rm.RawBody()
+	oversized := sendBody(ctx.requestURI, rm.Header()) && len(rawBody) > maxBodySize
+	ctx.authReq.BodyTruncated = oversized
+	if sendBody(ctx.requestURI, rm.Header()) && !oversized {
+		ctx.authReq.ResponseBody = rawBody
 	}
 	for _, plugin := range ctx.plugins {
 		log.G(context.TODO()).Debugf("AuthZ response using plugin %s", plugin.Name())
 
-		authRes, err := plugin.AuthZResponse(ctx.authReq)
+		var (
+			authRes *Response
+			err     error
+		)
+		if oversized {
+			if sp, ok := asStreamingPlugin(plugin); ok {
+				streamReq := *ctx.authReq
+				streamReq.BodyStreamID = stringid.GenerateRandomID()
+				streamReq.BodyTruncated = false
+				authRes, err = sp.AuthZResponseStream(&streamReq, bytes.NewReader(rawBody))
+			} else {
+				authRes, err = plugin.AuthZResponse(ctx.authReq)
+			}
+		} else {
+			authRes, err = plugin.AuthZResponse(ctx.authReq)
+		}
 		if err != nil {
 			return fmt.Errorf("plugin %s failed with error: %s", plugin.Name(), err)
 		}
@@ -142,23 +214,34 @@ func (ctx *Ctx) AuthZResponse(rm ResponseModifier, r *http.Request) error {
 	return nil
 }
 
-// drainBody dump the body (if its length is less than 1MB) without modifying the request state
-func drainBody(body io.ReadCloser) ([]byte, io.ReadCloser, error) {
-	bufReader := bufio.NewReaderSize(body, maxBodySize)
-	newBody := ioutils.NewReadCloserWrapper(bufReader, func() error { return body.Close() })
+// peekBody determines whether body is over maxBodySize by peeking
+// maxBodySize+1 bytes from it, without consuming body for whatever reads it
+// next: newBody replays exactly what was peeked followed by whatever is
+// left unread.
+//
+// This looks at the bytes actually read rather than r.ContentLength, unlike
+// the check this replaces, because ContentLength is -1 for a
+// chunked-Transfer-Encoding request -- image pushes, build context
+// uploads, and large exec payloads are routinely sent chunked, and the old
+// length-based check silently treated every one of them as within-bounds
+// no matter how large the body actually was.
+func peekBody(body io.ReadCloser) (data []byte, oversized bool, newBody io.ReadCloser, err error) {
+	bufReader := bufio.NewReaderSize(body, maxBodySize+1)
+	newBody = ioutils.NewReadCloserWrapper(bufReader, func() error { return body.Close() })
 
-	data, err := bufReader.Peek(maxBodySize)
-	// Body size exceeds max body size
-	if err == nil {
+	peeked, err := bufReader.Peek(maxBodySize + 1)
+	switch err {
+	case nil:
+		// Body size exceeds max body size
 		log.G(context.TODO()).Warnf("Request body is larger than: '%d' skipping body", maxBodySize)
-		return nil, newBody, nil
-	}
-	// Body size is less than maximum size
-	if err == io.EOF {
-		return data, newBody, nil
+		return nil, true, newBody, nil
+	case io.EOF:
+		// Body size is less than maximum size
+		return peeked, false, newBody, nil
+	default:
+		// Unknown error
+		return nil, false, newBody, err
 	}
-	// Unknown error
-	return nil, newBody, err
 }
 
 func isAuthEndpoint(urlPath string) (bool, error) {
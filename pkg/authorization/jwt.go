@@ -0,0 +1,368 @@
+package authorization
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrNoBearerToken is returned by JWTValidator.Authenticate when the incoming
+// request carries no "Bearer" Authorization header, so callers can fall
+// back to another authentication method (e.g. TLS client certificates)
+// without treating the absence of a token as an error.
+var ErrNoBearerToken = errors.New("authorization: no bearer token present")
+
+// JWTAuthOptions is the daemon.json "authentication.jwt" block:
+//
+//	{
+//	  "authentication": {
+//	    "jwt": {
+//	      "jwks_url": "https://issuer.example.com/.well-known/jwks.json",
+//	      "issuer": "https://issuer.example.com/",
+//	      "audience": "docker-daemon",
+//	      "username_claim": "sub",
+//	      "groups_claim": "groups"
+//	    }
+//	  }
+//	}
+type JWTAuthOptions struct {
+	// JWKSURL is the HTTPS endpoint the daemon fetches signing keys from.
+	JWKSURL string `json:"jwks_url"`
+
+	// Issuer, if set, must match the token's "iss" claim.
+	Issuer string `json:"issuer"`
+
+	// Audience, if set, must appear in the token's "aud" claim.
+	Audience string `json:"audience"`
+
+	// UsernameClaim names the claim used to populate Request.User. Defaults
+	// to "sub".
+	UsernameClaim string `json:"username_claim"`
+
+	// GroupsClaim names the claim (expected to be a string array) used to
+	// populate the resolved groups. Defaults to "groups".
+	GroupsClaim string `json:"groups_claim"`
+
+	// ClockSkew bounds how far exp/nbf are allowed to drift from the
+	// daemon's clock. Defaults to 1 minute.
+	ClockSkew time.Duration `json:"clock_skew"`
+
+	// HS256Secret, when set, allows the JWKS to be bypassed in favor of a
+	// single shared HMAC secret. Mainly useful for testing.
+	HS256Secret string `json:"hs256_secret,omitempty"`
+}
+
+func (o JWTAuthOptions) usernameClaim() string {
+	if o.UsernameClaim == "" {
+		return "sub"
+	}
+	return o.UsernameClaim
+}
+
+func (o JWTAuthOptions) groupsClaim() string {
+	if o.GroupsClaim == "" {
+		return "groups"
+	}
+	return o.GroupsClaim
+}
+
+func (o JWTAuthOptions) clockSkew() time.Duration {
+	if o.ClockSkew <= 0 {
+		return time.Minute
+	}
+	return o.ClockSkew
+}
+
+// JWTValidator validates bearer tokens against a JWKS endpoint, caching keys
+// across requests and refetching on an unknown "kid" (to ride out JWKS key
+// rotation without restarting the daemon).
+//
+// JWTValidator is self-contained: the HTTP middleware that would call
+// Authenticate per-request and feed its result into NewCtxWithClaims (the
+// way TLS client-cert auth is wired into the existing authorization
+// middleware today) has not been added yet, so nothing in the daemon
+// constructs one from JWTAuthOptions yet. Wire it up alongside whatever
+// reads "authentication.jwt" out of daemon.json.
+type JWTValidator struct {
+	opts JWTAuthOptions
+
+	httpClient *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]any // kid -> *rsa.PublicKey / *ecdsa.PublicKey
+	fetchedAt time.Time
+}
+
+// NewJWTValidator creates a validator for the given daemon.json
+// authentication.jwt options.
+func NewJWTValidator(opts JWTAuthOptions) *JWTValidator {
+	return &JWTValidator{
+		opts:       opts,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		keys:       map[string]any{},
+	}
+}
+
+// bearerToken extracts the raw token from an "Authorization: Bearer <token>"
+// header, returning ErrNoBearerToken when the header is absent or uses a
+// different scheme (so TLS client-cert auth can be tried instead).
+func bearerToken(r *http.Request) (string, error) {
+	h := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if !strings.HasPrefix(h, prefix) {
+		return "", ErrNoBearerToken
+	}
+	tok := strings.TrimSpace(strings.TrimPrefix(h, prefix))
+	if tok == "" {
+		return "", ErrNoBearerToken
+	}
+	return tok, nil
+}
+
+// Authenticate validates the bearer token on r, if any, and returns the
+// resolved username, groups and raw claims. It returns ErrNoBearerToken
+// when r carries no bearer token, so the caller can fall back to
+// the existing TLS client-cert flow.
+func (v *JWTValidator) Authenticate(ctx context.Context, r *http.Request) (user string, groups []string, claims map[string]any, err error) {
+	raw, err := bearerToken(r)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	token, err := jwt.Parse(raw, v.keyFunc(ctx), jwt.WithValidMethods(allowedAlgs), jwt.WithLeeway(v.opts.clockSkew()))
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("jwt: %w", err)
+	}
+	mapClaims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return "", nil, nil, errors.New("jwt: invalid token")
+	}
+
+	if v.opts.Issuer != "" {
+		iss, _ := mapClaims["iss"].(string)
+		if iss != v.opts.Issuer {
+			return "", nil, nil, fmt.Errorf("jwt: unexpected issuer %q", iss)
+		}
+	}
+	if v.opts.Audience != "" && !mapClaims.VerifyAudience(v.opts.Audience, true) {
+		return "", nil, nil, fmt.Errorf("jwt: audience %q not accepted", v.opts.Audience)
+	}
+
+	user, _ = mapClaims[v.opts.usernameClaim()].(string)
+	if user == "" {
+		return "", nil, nil, fmt.Errorf("jwt: claim %q missing or empty", v.opts.usernameClaim())
+	}
+	if raw, ok := mapClaims[v.opts.groupsClaim()].([]any); ok {
+		for _, g := range raw {
+			if s, ok := g.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+	}
+
+	return user, groups, mapClaims, nil
+}
+
+// allowedAlgs are the signing algorithms JWTValidator accepts. "none" is
+// deliberately excluded: github.com/golang-jwt/jwt rejects it by default,
+// but this is listed explicitly for anyone reading the list to audit it.
+var allowedAlgs = []string{
+	"HS256", "HS384", "HS512",
+	"RS256", "RS384", "RS512",
+	"ES256", "ES384", "ES512",
+}
+
+func (v *JWTValidator) keyFunc(ctx context.Context) jwt.Keyfunc {
+	return func(token *jwt.Token) (any, error) {
+		if token.Method == jwt.SigningMethodNone {
+			return nil, errors.New("jwt: alg \"none\" is not accepted")
+		}
+		if v.opts.HS256Secret != "" {
+			return []byte(v.opts.HS256Secret), nil
+		}
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			return nil, errors.New("jwt: token has no \"kid\" header")
+		}
+		return v.lookupKey(ctx, kid)
+	}
+}
+
+// lookupKey returns the public key for kid, refreshing the JWKS once (and
+// only once per call) if kid isn't in the cache, to pick up keys rotated in
+// since the last fetch without needing a daemon restart.
+func (v *JWTValidator) lookupKey(ctx context.Context, kid string) (any, error) {
+	v.mu.Lock()
+	key, ok := v.keys[kid]
+	v.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	if err := v.refreshJWKS(ctx); err != nil {
+		return nil, err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	key, ok = v.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("jwt: unknown key id %q", kid)
+	}
+	return key, nil
+}
+
+type jwkSet struct {
+	Keys []jwk `json:"keys"`
+}
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	// Crv, X and Y are the EC point coordinates (kty "EC"); N and E are the
+	// RSA modulus/exponent (kty "RSA"). All four are base64url-encoded,
+	// unpadded, big-endian integers per RFC 7518 §6.3/§6.2. X5c is the
+	// alternative certificate-chain form some issuers publish instead.
+	N   string   `json:"n"`
+	E   string   `json:"e"`
+	Crv string   `json:"crv"`
+	X   string   `json:"x"`
+	Y   string   `json:"y"`
+	X5c []string `json:"x5c"`
+}
+
+func (v *JWTValidator) refreshJWKS(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.opts.JWKSURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := v.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("jwt: fetching JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("jwt: fetching JWKS: unexpected status %s", resp.Status)
+	}
+
+	var set jwkSet
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("jwt: decoding JWKS: %w", err)
+	}
+
+	keys := make(map[string]any, len(set.Keys))
+	for _, k := range set.Keys {
+		pub, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+
+	v.mu.Lock()
+	v.keys = keys
+	v.fetchedAt = time.Now()
+	v.mu.Unlock()
+	return nil
+}
+
+func (k jwk) publicKey() (any, error) {
+	if len(k.X5c) > 0 {
+		der, err := base64.StdEncoding.DecodeString(k.X5c[0])
+		if err != nil {
+			return nil, err
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	}
+
+	switch k.Kty {
+	case "RSA":
+		return k.rsaPublicKey()
+	case "EC":
+		return k.ecPublicKey()
+	default:
+		return nil, fmt.Errorf("jwt: unsupported key type %q for kid %q", k.Kty, k.Kid)
+	}
+}
+
+func (k jwk) rsaPublicKey() (*rsa.PublicKey, error) {
+	if k.N == "" || k.E == "" {
+		return nil, fmt.Errorf("jwt: RSA key %q missing n/e", k.Kid)
+	}
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("jwt: decoding n for kid %q: %w", k.Kid, err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("jwt: decoding e for kid %q: %w", k.Kid, err)
+	}
+	exp := new(big.Int).SetBytes(e)
+	if exp.Sign() == 0 || !exp.IsInt64() {
+		return nil, fmt.Errorf("jwt: invalid exponent for kid %q", k.Kid)
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(exp.Int64()),
+	}, nil
+}
+
+func (k jwk) ecPublicKey() (*ecdsa.PublicKey, error) {
+	if k.X == "" || k.Y == "" {
+		return nil, fmt.Errorf("jwt: EC key %q missing x/y", k.Kid)
+	}
+	curve, err := ecCurve(k.Crv)
+	if err != nil {
+		return nil, fmt.Errorf("jwt: kid %q: %w", k.Kid, err)
+	}
+	x, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil {
+		return nil, fmt.Errorf("jwt: decoding x for kid %q: %w", k.Kid, err)
+	}
+	y, err := base64.RawURLEncoding.DecodeString(k.Y)
+	if err != nil {
+		return nil, fmt.Errorf("jwt: decoding y for kid %q: %w", k.Kid, err)
+	}
+	pub := &ecdsa.PublicKey{
+		Curve: curve,
+		X:     new(big.Int).SetBytes(x),
+		Y:     new(big.Int).SetBytes(y),
+	}
+	if !curve.IsOnCurve(pub.X, pub.Y) {
+		return nil, fmt.Errorf("jwt: kid %q: point is not on curve %s", k.Kid, k.Crv)
+	}
+	return pub, nil
+}
+
+// ecCurve maps a JWK "crv" value to its Go elliptic.Curve, covering the
+// three NIST curves RFC 7518 §6.2.1.1 defines for kty "EC".
+func ecCurve(crv string) (elliptic.Curve, error) {
+	switch crv {
+	case "P-256":
+		return elliptic.P256(), nil
+	case "P-384":
+		return elliptic.P384(), nil
+	case "P-521":
+		return elliptic.P521(), nil
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", crv)
+	}
+}
@@ -0,0 +1,170 @@
+package authorization
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newTestJWKSServer(t *testing.T, kid string, pub *rsa.PublicKey) *httptest.Server {
+	t.Helper()
+	set := jwkSet{Keys: []jwk{{
+		Kid: kid,
+		Kty: "RSA",
+		Alg: "RS256",
+		N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func signTestToken(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	tok := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	tok.Header["kid"] = kid
+	signed, err := tok.SignedString(key)
+	if err != nil {
+		t.Fatalf("signing test token: %v", err)
+	}
+	return signed
+}
+
+func newTestValidator(t *testing.T, opts JWTAuthOptions) (*JWTValidator, *rsa.PrivateKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating test key: %v", err)
+	}
+	opts.JWKSURL = newTestJWKSServer(t, "test-kid", &key.PublicKey).URL
+	return NewJWTValidator(opts), key
+}
+
+func TestJWTValidatorAccepts(t *testing.T) {
+	v, key := newTestValidator(t, JWTAuthOptions{})
+	raw := signTestToken(t, key, "test-kid", jwt.MapClaims{
+		"sub": "alice",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer "+raw)
+
+	user, _, claims, err := v.Authenticate(context.Background(), r)
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if user != "alice" {
+		t.Errorf("user = %q, want alice", user)
+	}
+	if claims["sub"] != "alice" {
+		t.Errorf("claims[sub] = %v, want alice", claims["sub"])
+	}
+}
+
+func TestJWTValidatorClockSkew(t *testing.T) {
+	v, key := newTestValidator(t, JWTAuthOptions{ClockSkew: 2 * time.Minute})
+	raw := signTestToken(t, key, "test-kid", jwt.MapClaims{
+		"sub": "alice",
+		"exp": time.Now().Add(-time.Minute).Unix(),
+	})
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer "+raw)
+
+	if _, _, _, err := v.Authenticate(context.Background(), r); err != nil {
+		t.Fatalf("Authenticate: expected token within clock skew to be accepted, got %v", err)
+	}
+}
+
+func TestJWTValidatorExpiredBeyondClockSkew(t *testing.T) {
+	v, key := newTestValidator(t, JWTAuthOptions{})
+	raw := signTestToken(t, key, "test-kid", jwt.MapClaims{
+		"sub": "alice",
+		"exp": time.Now().Add(-time.Hour).Unix(),
+	})
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer "+raw)
+
+	if _, _, _, err := v.Authenticate(context.Background(), r); err == nil {
+		t.Fatal("Authenticate: expected expired token to be rejected")
+	}
+}
+
+func TestJWTValidatorIssuerMismatch(t *testing.T) {
+	v, key := newTestValidator(t, JWTAuthOptions{Issuer: "https://issuer.example.com/"})
+	raw := signTestToken(t, key, "test-kid", jwt.MapClaims{
+		"sub": "alice",
+		"iss": "https://someone-else.example.com/",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer "+raw)
+
+	if _, _, _, err := v.Authenticate(context.Background(), r); err == nil {
+		t.Fatal("Authenticate: expected issuer mismatch to be rejected")
+	}
+}
+
+func TestJWTValidatorAudienceMismatch(t *testing.T) {
+	v, key := newTestValidator(t, JWTAuthOptions{Audience: "docker-daemon"})
+	raw := signTestToken(t, key, "test-kid", jwt.MapClaims{
+		"sub": "alice",
+		"aud": "someone-else",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer "+raw)
+
+	if _, _, _, err := v.Authenticate(context.Background(), r); err == nil {
+		t.Fatal("Authenticate: expected audience mismatch to be rejected")
+	}
+}
+
+func TestJWTValidatorMissingUsernameClaim(t *testing.T) {
+	v, key := newTestValidator(t, JWTAuthOptions{})
+	raw := signTestToken(t, key, "test-kid", jwt.MapClaims{
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer "+raw)
+
+	if _, _, _, err := v.Authenticate(context.Background(), r); err == nil {
+		t.Fatal("Authenticate: expected missing username claim to be rejected")
+	}
+}
+
+func TestJWTValidatorRejectsAlgNone(t *testing.T) {
+	v, _ := newTestValidator(t, JWTAuthOptions{})
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer "+unsafeNoneToken(t, jwt.MapClaims{
+		"sub": "alice",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}))
+
+	if _, _, _, err := v.Authenticate(context.Background(), r); err == nil {
+		t.Fatal("Authenticate: expected alg \"none\" token to be rejected")
+	}
+}
+
+// unsafeNoneToken builds a token signed with jwt.SigningMethodNone, which is
+// what a client attempting an "alg: none" downgrade attack would send.
+func unsafeNoneToken(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	tok := jwt.NewWithClaims(jwt.SigningMethodNone, claims)
+	signed, err := tok.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatalf("signing none token: %v", err)
+	}
+	return signed
+}
@@ -0,0 +1,14 @@
+package authorization
+
+// Plugin allows third-party plugins to authorize requests and responses
+// sent to/from the Docker daemon.
+type Plugin interface {
+	// Name returns the registered plugin name
+	Name() string
+
+	// AuthZRequest authorizes the request from the client to the daemon
+	AuthZRequest(*Request) (*Response, error)
+
+	// AuthZResponse authorizes the response from the daemon to the client
+	AuthZResponse(*Request) (*Response, error)
+}
@@ -0,0 +1,69 @@
+// Package authzv2 is hand-written to mirror authz.proto, rather than
+// generated by protoc-gen-go -- there is no protoc toolchain in this build
+// environment to regenerate it from. The message types below are plain Go
+// structs, not real protobuf messages (no Reset/String/ProtoReflect), so
+// they're sent over grpc using the jsonCodec in codec.go rather than the
+// default "proto" codec; update authz.proto and this file together by hand
+// when the wire schema changes.
+package authzv2
+
+// HandshakeRequest is the Handshake RPC request message.
+type HandshakeRequest struct {
+	PluginName string
+}
+
+// HandshakeResponse is the Handshake RPC response message.
+type HandshakeResponse struct {
+	Capabilities []string
+}
+
+// RequestMetadata carries everything about an AuthZRequest other than the
+// (possibly chunked) body.
+type RequestMetadata struct {
+	User                      string
+	UserAuthNMethod           string
+	RequestMethod             string
+	RequestURI                string
+	RequestHeaders            map[string]string
+	RequestPeerCertificateDER []byte
+	RequestClaimsJSON         map[string]string
+	BodyStreamID              string
+	BodyTruncated             bool
+}
+
+// ResponseMetadata carries everything about an AuthZResponse other than the
+// (possibly chunked) body.
+type ResponseMetadata struct {
+	ResponseStatusCode int32
+	ResponseHeaders    map[string]string
+	BodyStreamID       string
+	BodyTruncated      bool
+}
+
+// BodyChunk is one frame of a streamed request/response body.
+type BodyChunk struct {
+	Data []byte
+	Last bool
+}
+
+// AuthZRequestMessage is a frame sent on the AuthZRequest stream: either the
+// metadata (sent first) or a body chunk (sent zero or more times after).
+type AuthZRequestMessage struct {
+	Metadata *RequestMetadata
+	Chunk    *BodyChunk
+}
+
+// AuthZResponseMessage is the AuthZResponse-stream counterpart of
+// AuthZRequestMessage.
+type AuthZResponseMessage struct {
+	Metadata *ResponseMetadata
+	Chunk    *BodyChunk
+}
+
+// AuthZResponse is the verdict returned by both the AuthZRequest and
+// AuthZResponse RPCs.
+type AuthZResponse struct {
+	Allow bool
+	Msg   string
+	Err   string
+}
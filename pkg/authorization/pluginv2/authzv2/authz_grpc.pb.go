@@ -0,0 +1,236 @@
+// Hand-written to mirror the client/server stubs protoc-gen-go-grpc would
+// generate from authz.proto (see authz.pb.go for why this isn't actually
+// generated code). Every RPC is issued with the jsonCodec content-subtype
+// from codec.go instead of the default "proto" one.
+
+package authzv2
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+)
+
+const (
+	AuthZPlugin_Handshake_FullMethodName     = "/authzv2.AuthZPlugin/Handshake"
+	AuthZPlugin_AuthZRequest_FullMethodName  = "/authzv2.AuthZPlugin/AuthZRequest"
+	AuthZPlugin_AuthZResponse_FullMethodName = "/authzv2.AuthZPlugin/AuthZResponse"
+)
+
+// AuthZPluginClient is the client API for AuthZPlugin.
+type AuthZPluginClient interface {
+	Handshake(ctx context.Context, in *HandshakeRequest, opts ...grpc.CallOption) (*HandshakeResponse, error)
+	AuthZRequest(ctx context.Context, opts ...grpc.CallOption) (AuthZPlugin_AuthZRequestClient, error)
+	AuthZResponse(ctx context.Context, opts ...grpc.CallOption) (AuthZPlugin_AuthZResponseClient, error)
+}
+
+type authZPluginClient struct {
+	cc grpc.ClientConnInterface
+}
+
+// NewAuthZPluginClient wraps cc (typically a gRPC connection to a managed
+// plugin's unix socket) as an AuthZPluginClient.
+func NewAuthZPluginClient(cc grpc.ClientConnInterface) AuthZPluginClient {
+	return &authZPluginClient{cc}
+}
+
+func (c *authZPluginClient) Handshake(ctx context.Context, in *HandshakeRequest, opts ...grpc.CallOption) (*HandshakeResponse, error) {
+	out := new(HandshakeResponse)
+	opts = append(opts, grpc.CallContentSubtype(codecName))
+	if err := c.cc.Invoke(ctx, AuthZPlugin_Handshake_FullMethodName, in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AuthZPlugin_AuthZRequestClient is the client-side stream for AuthZRequest:
+// callers Send RequestMetadata followed by zero or more BodyChunks, then
+// call CloseAndRecv for the verdict.
+type AuthZPlugin_AuthZRequestClient interface {
+	Send(*AuthZRequestMessage) error
+	CloseAndRecv() (*AuthZResponse, error)
+	grpc.ClientStream
+}
+
+func (c *authZPluginClient) AuthZRequest(ctx context.Context, opts ...grpc.CallOption) (AuthZPlugin_AuthZRequestClient, error) {
+	opts = append(opts, grpc.CallContentSubtype(codecName))
+	stream, err := c.cc.NewStream(ctx, &grpc.StreamDesc{ClientStreams: true}, AuthZPlugin_AuthZRequest_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &authZRequestClient{stream}, nil
+}
+
+type authZRequestClient struct {
+	grpc.ClientStream
+}
+
+func (x *authZRequestClient) Send(m *AuthZRequestMessage) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *authZRequestClient) CloseAndRecv() (*AuthZResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	out := new(AuthZResponse)
+	if err := x.ClientStream.RecvMsg(out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AuthZPlugin_AuthZResponseClient is the AuthZResponse-RPC counterpart of
+// AuthZPlugin_AuthZRequestClient.
+type AuthZPlugin_AuthZResponseClient interface {
+	Send(*AuthZResponseMessage) error
+	CloseAndRecv() (*AuthZResponse, error)
+	grpc.ClientStream
+}
+
+func (c *authZPluginClient) AuthZResponse(ctx context.Context, opts ...grpc.CallOption) (AuthZPlugin_AuthZResponseClient, error) {
+	opts = append(opts, grpc.CallContentSubtype(codecName))
+	stream, err := c.cc.NewStream(ctx, &grpc.StreamDesc{ClientStreams: true}, AuthZPlugin_AuthZResponse_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &authZResponseClient{stream}, nil
+}
+
+type authZResponseClient struct {
+	grpc.ClientStream
+}
+
+func (x *authZResponseClient) Send(m *AuthZResponseMessage) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *authZResponseClient) CloseAndRecv() (*AuthZResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	out := new(AuthZResponse)
+	if err := x.ClientStream.RecvMsg(out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AuthZPluginServer is the server API for AuthZPlugin.
+type AuthZPluginServer interface {
+	Handshake(context.Context, *HandshakeRequest) (*HandshakeResponse, error)
+	AuthZRequest(AuthZPlugin_AuthZRequestServer) error
+	AuthZResponse(AuthZPlugin_AuthZResponseServer) error
+}
+
+// UnimplementedAuthZPluginServer can be embedded in a plugin's server
+// implementation for forward compatibility: methods not overridden fail
+// with "not implemented" instead of causing a compile error when the
+// service gains new RPCs.
+type UnimplementedAuthZPluginServer struct{}
+
+func (UnimplementedAuthZPluginServer) Handshake(context.Context, *HandshakeRequest) (*HandshakeResponse, error) {
+	return &HandshakeResponse{}, nil
+}
+
+func (UnimplementedAuthZPluginServer) AuthZRequest(AuthZPlugin_AuthZRequestServer) error {
+	return errUnimplemented("AuthZRequest")
+}
+
+func (UnimplementedAuthZPluginServer) AuthZResponse(AuthZPlugin_AuthZResponseServer) error {
+	return errUnimplemented("AuthZResponse")
+}
+
+func errUnimplemented(method string) error {
+	return fmt.Errorf("authzv2: method %s not implemented", method)
+}
+
+// AuthZPlugin_AuthZRequestServer is the server-side stream for AuthZRequest.
+type AuthZPlugin_AuthZRequestServer interface {
+	Recv() (*AuthZRequestMessage, error)
+	SendAndClose(*AuthZResponse) error
+	grpc.ServerStream
+}
+
+// AuthZPlugin_AuthZResponseServer is the server-side stream for
+// AuthZResponse.
+type AuthZPlugin_AuthZResponseServer interface {
+	Recv() (*AuthZResponseMessage, error)
+	SendAndClose(*AuthZResponse) error
+	grpc.ServerStream
+}
+
+type authZRequestServer struct {
+	grpc.ServerStream
+}
+
+func (x *authZRequestServer) Recv() (*AuthZRequestMessage, error) {
+	m := new(AuthZRequestMessage)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (x *authZRequestServer) SendAndClose(m *AuthZResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+type authZResponseServer struct {
+	grpc.ServerStream
+}
+
+func (x *authZResponseServer) Recv() (*AuthZResponseMessage, error) {
+	m := new(AuthZResponseMessage)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (x *authZResponseServer) SendAndClose(m *AuthZResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+var authZPlugin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "authzv2.AuthZPlugin",
+	HandlerType: (*AuthZPluginServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Handshake",
+			Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+				in := new(HandshakeRequest)
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				if interceptor == nil {
+					return srv.(AuthZPluginServer).Handshake(ctx, in)
+				}
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: AuthZPlugin_Handshake_FullMethodName}
+				handler := func(ctx context.Context, req any) (any, error) {
+					return srv.(AuthZPluginServer).Handshake(ctx, req.(*HandshakeRequest))
+				}
+				return interceptor(ctx, in, info, handler)
+			},
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "AuthZRequest",
+			Handler:       func(srv any, stream grpc.ServerStream) error { return srv.(AuthZPluginServer).AuthZRequest(&authZRequestServer{stream}) },
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "AuthZResponse",
+			Handler:       func(srv any, stream grpc.ServerStream) error { return srv.(AuthZPluginServer).AuthZResponse(&authZResponseServer{stream}) },
+			ClientStreams: true,
+		},
+	},
+}
+
+// RegisterAuthZPluginServer registers srv as the AuthZPlugin implementation
+// served by s.
+func RegisterAuthZPluginServer(s grpc.ServiceRegistrar, srv AuthZPluginServer) {
+	s.RegisterService(&authZPlugin_ServiceDesc, srv)
+}
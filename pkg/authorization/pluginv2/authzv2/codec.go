@@ -0,0 +1,39 @@
+package authzv2
+
+import (
+	"encoding/json"
+
+	"google.golang.org/grpc/encoding"
+)
+
+// codecName is the gRPC content-subtype this package's messages are sent
+// under ("application/grpc+authzv2json"), distinct from the real protobuf
+// wire format ("application/grpc+proto"). The messages in authz.pb.go
+// (hand-written, not protoc output) are plain Go structs with no protoreflect/legacy proto.Message
+// implementation, so they cannot go through grpc's default "proto" codec;
+// registering a codec under our own subtype lets this package keep using
+// real google.golang.org/grpc (HTTP/2 framing, flow control, streaming)
+// without claiming protobuf wire compatibility it doesn't have, and without
+// overriding the "proto" codec other grpc users in the same process (e.g.
+// the containerd client) depend on.
+const codecName = "authzv2json"
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// jsonCodec implements encoding.Codec by marshaling the authzv2 message
+// structs as JSON. Every field in those structs is already exported and
+// JSON-safe (strings, []byte, maps, bools), so no wire-format-specific
+// struct tags are needed.
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return codecName }
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,206 @@
+// Package pluginv2 adapts Docker managed plugins (v2, OCI-packaged) that
+// speak the authzv2 gRPC protocol to the authorization.Plugin (and, for
+// plugins that negotiate it, authorization.StreamingPlugin) interfaces, so
+// the daemon's authorization.Ctx can use v2 plugins exactly like the legacy
+// JSON-over-HTTP ones.
+package pluginv2
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/moby/moby/v2/pkg/authorization"
+	"github.com/moby/moby/v2/pkg/authorization/pluginv2/authzv2"
+	"google.golang.org/grpc"
+)
+
+// bodyChunkSize caps how much of a streamed body is sent per gRPC message;
+// large enough to amortize framing overhead, small enough to keep a single
+// chunk from dominating the plugin's flow-control window.
+const bodyChunkSize = 256 * 1024
+
+// Client adapts a single managed plugin's gRPC connection to
+// authorization.Plugin / authorization.StreamingPlugin.
+type Client struct {
+	name string
+	rpc  authzv2.AuthZPluginClient
+
+	mu           sync.RWMutex
+	capabilities []string
+}
+
+// NewClient negotiates capabilities with the plugin behind cc (a connection
+// to its gRPC socket, as resolved by the managed plugin manager) and returns
+// a Client ready to be registered as an authorization.Plugin.
+func NewClient(ctx context.Context, name string, cc grpc.ClientConnInterface) (*Client, error) {
+	rpc := authzv2.NewAuthZPluginClient(cc)
+	hs, err := rpc.Handshake(ctx, &authzv2.HandshakeRequest{PluginName: name})
+	if err != nil {
+		return nil, fmt.Errorf("pluginv2: handshake with %s failed: %w", name, err)
+	}
+	return &Client{name: name, rpc: rpc, capabilities: hs.Capabilities}, nil
+}
+
+// Name implements authorization.Plugin.
+func (c *Client) Name() string { return c.name }
+
+// Capabilities implements authorization.StreamingPlugin.
+func (c *Client) Capabilities() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]string(nil), c.capabilities...)
+}
+
+// updateCapabilities is called when the managed plugin manager reports a
+// lifecycle event (enable/disable/upgrade) for this plugin, so an in-flight
+// request sees a consistent capability set rather than one that changed out
+// from under it mid-request.
+func (c *Client) updateCapabilities(caps []string) {
+	c.mu.Lock()
+	c.capabilities = caps
+	c.mu.Unlock()
+}
+
+// AuthZRequest implements authorization.Plugin using a single-frame
+// (non-streamed) request.
+func (c *Client) AuthZRequest(req *authorization.Request) (*authorization.Response, error) {
+	var body io.Reader
+	if len(req.RequestBody) > 0 {
+		body = bytes.NewReader(req.RequestBody)
+	}
+	return c.authZRequestStream(context.Background(), requestMetadata(req), body)
+}
+
+// AuthZResponse implements authorization.Plugin using a single-frame
+// (non-streamed) response.
+func (c *Client) AuthZResponse(req *authorization.Request) (*authorization.Response, error) {
+	var body io.Reader
+	if len(req.ResponseBody) > 0 {
+		body = bytes.NewReader(req.ResponseBody)
+	}
+	return c.authZResponseStream(context.Background(), responseMetadata(req), body)
+}
+
+// AuthZRequestStream implements authorization.StreamingPlugin.
+func (c *Client) AuthZRequestStream(req *authorization.Request, body io.Reader) (*authorization.Response, error) {
+	return c.authZRequestStream(context.Background(), requestMetadata(req), body)
+}
+
+// AuthZResponseStream implements authorization.StreamingPlugin.
+func (c *Client) AuthZResponseStream(req *authorization.Request, body io.Reader) (*authorization.Response, error) {
+	return c.authZResponseStream(context.Background(), responseMetadata(req), body)
+}
+
+func (c *Client) authZRequestStream(ctx context.Context, metadata *authzv2.RequestMetadata, body io.Reader) (*authorization.Response, error) {
+	stream, err := c.rpc.AuthZRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.Send(&authzv2.AuthZRequestMessage{Metadata: metadata}); err != nil {
+		return nil, err
+	}
+	if body != nil {
+		if err := streamChunks(body, func(chunk *authzv2.BodyChunk) error {
+			return stream.Send(&authzv2.AuthZRequestMessage{Chunk: chunk})
+		}); err != nil {
+			return nil, err
+		}
+	}
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		return nil, err
+	}
+	return toResponse(res), nil
+}
+
+func (c *Client) authZResponseStream(ctx context.Context, metadata *authzv2.ResponseMetadata, body io.Reader) (*authorization.Response, error) {
+	stream, err := c.rpc.AuthZResponse(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.Send(&authzv2.AuthZResponseMessage{Metadata: metadata}); err != nil {
+		return nil, err
+	}
+	if body != nil {
+		if err := streamChunks(body, func(chunk *authzv2.BodyChunk) error {
+			return stream.Send(&authzv2.AuthZResponseMessage{Chunk: chunk})
+		}); err != nil {
+			return nil, err
+		}
+	}
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		return nil, err
+	}
+	return toResponse(res), nil
+}
+
+// streamChunks reads body in bodyChunkSize pieces, calling send for each
+// (marking the final one), until EOF, a plugin-initiated cancellation
+// (send returning an error), or a read error.
+func streamChunks(body io.Reader, send func(*authzv2.BodyChunk) error) error {
+	buf := make([]byte, bodyChunkSize)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			last := err == io.EOF
+			if serr := send(&authzv2.BodyChunk{Data: append([]byte(nil), buf[:n]...), Last: last}); serr != nil {
+				return serr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func requestMetadata(req *authorization.Request) *authzv2.RequestMetadata {
+	return &authzv2.RequestMetadata{
+		User:              req.User,
+		UserAuthNMethod:   req.UserAuthNMethod,
+		RequestMethod:     req.RequestMethod,
+		RequestURI:        req.RequestURI,
+		RequestHeaders:    req.RequestHeaders,
+		RequestClaimsJSON: marshalClaims(req.RequestClaims),
+		BodyStreamID:      req.BodyStreamID,
+		BodyTruncated:     req.BodyTruncated,
+	}
+}
+
+func responseMetadata(req *authorization.Request) *authzv2.ResponseMetadata {
+	return &authzv2.ResponseMetadata{
+		ResponseStatusCode: int32(req.ResponseStatusCode),
+		ResponseHeaders:    req.ResponseHeaders,
+		BodyStreamID:       req.BodyStreamID,
+		BodyTruncated:      req.BodyTruncated,
+	}
+}
+
+// marshalClaims flattens claim values to their JSON representation, since
+// the wire message represents RequestClaims as map[string]string for
+// simplicity rather than modeling arbitrary nested JSON in the .proto.
+func marshalClaims(claims map[string]any) map[string]string {
+	if len(claims) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(claims))
+	for k, v := range claims {
+		b, err := json.Marshal(v)
+		if err != nil {
+			continue
+		}
+		out[k] = string(b)
+	}
+	return out
+}
+
+func toResponse(res *authzv2.AuthZResponse) *authorization.Response {
+	return &authorization.Response{Allow: res.Allow, Msg: res.Msg, Err: res.Err}
+}
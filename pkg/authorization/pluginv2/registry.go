@@ -0,0 +1,63 @@
+package pluginv2
+
+import (
+	"sync"
+
+	"github.com/moby/moby/v2/pkg/authorization"
+)
+
+// Registry tracks the set of enabled v2 authorization plugins, as reported
+// by the managed plugin manager's enable/disable/upgrade lifecycle events.
+// Snapshot returns a stable view of that set, so a request that has already
+// started authorizing against a given plugin list isn't affected by a
+// plugin being disabled or upgraded partway through.
+type Registry struct {
+	mu      sync.RWMutex
+	clients map[string]*Client // keyed by plugin name
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{clients: map[string]*Client{}}
+}
+
+// Enabled is called by the managed plugin manager when a v2 authz plugin is
+// enabled (or upgraded and re-enabled), after a successful handshake.
+func (r *Registry) Enabled(c *Client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clients[c.Name()] = c
+}
+
+// Disabled is called when a v2 authz plugin is disabled, removed, or fails
+// its handshake after an upgrade.
+func (r *Registry) Disabled(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.clients, name)
+}
+
+// CapabilitiesChanged is called when a live plugin renegotiates its
+// capabilities (e.g. after an upgrade that keeps the same gRPC connection).
+func (r *Registry) CapabilitiesChanged(name string, capabilities []string) {
+	r.mu.RLock()
+	c, ok := r.clients[name]
+	r.mu.RUnlock()
+	if ok {
+		c.updateCapabilities(capabilities)
+	}
+}
+
+// Snapshot returns the currently enabled plugins as authorization.Plugin
+// values, suitable for passing to authorization.NewCtx. The returned slice
+// is a point-in-time copy: later Registry updates don't affect a Ctx built
+// from it.
+func (r *Registry) Snapshot() []authorization.Plugin {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	plugins := make([]authorization.Plugin, 0, len(r.clients))
+	for _, c := range r.clients {
+		plugins = append(plugins, c)
+	}
+	return plugins
+}
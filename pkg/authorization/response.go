@@ -0,0 +1,15 @@
+package authorization
+
+import "net/http"
+
+// ResponseModifier gives Ctx.AuthZResponse read access to the daemon's
+// buffered response (headers, status code and raw body) for the plugins,
+// and FlushAll sends that response to the client once every plugin has
+// allowed it. Plugins cannot rewrite the response through it: a plugin's
+// Response carries only a verdict and messages.
+type ResponseModifier interface {
+	Header() http.Header
+	StatusCode() int
+	RawBody() []byte
+	FlushAll() error
+}
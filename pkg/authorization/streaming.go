@@ -0,0 +1,51 @@
+package authorization
+
+import "io"
+
+// CapabilityStreamingBody is advertised by a plugin at registration time to
+// indicate it implements StreamingPlugin and wants to receive oversized
+// request/response bodies as a stream rather than have them dropped once
+// they exceed maxBodySize.
+const CapabilityStreamingBody = "StreamingBody"
+
+// StreamingPlugin is implemented by authorization plugins that advertise
+// CapabilityStreamingBody. Ctx uses it in place of Plugin for any request
+// whose body exceeds maxBodySize; requests within maxBodySize keep using the
+// regular, fully-buffered Plugin methods even for a plugin that also
+// implements StreamingPlugin.
+type StreamingPlugin interface {
+	Plugin
+
+	// Capabilities returns the capabilities this plugin negotiated at
+	// registration. Ctx consults it to decide whether a plugin can be handed
+	// an oversized body via the streaming path.
+	Capabilities() []string
+
+	// AuthZRequestStream behaves like Plugin.AuthZRequest, except body
+	// streams the request body instead of it being pre-buffered in full.
+	// The plugin may return a verdict having read only a prefix of body
+	// (e.g. after inspecting a manifest at the start of an image tarball);
+	// whatever it read is replayed ahead of the rest of body for the
+	// daemon, so a partial read never drops bytes.
+	AuthZRequestStream(req *Request, body io.Reader) (*Response, error)
+
+	// AuthZResponseStream is the response-path counterpart of
+	// AuthZRequestStream.
+	AuthZResponseStream(req *Request, body io.Reader) (*Response, error)
+}
+
+// asStreamingPlugin returns p as a StreamingPlugin when it implements the
+// interface and has negotiated CapabilityStreamingBody, so callers can
+// decide per-plugin whether to take the streaming or the buffered path.
+func asStreamingPlugin(p Plugin) (StreamingPlugin, bool) {
+	sp, ok := p.(StreamingPlugin)
+	if !ok {
+		return nil, false
+	}
+	for _, c := range sp.Capabilities() {
+		if c == CapabilityStreamingBody {
+			return sp, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,82 @@
+// Package registry resolves, per registry host, which endpoint(s) the
+// daemon talks to and which credentials it authenticates with. It mirrors
+// the per-host hosts.toml model used by containerd's CRI plugin: each host
+// can define its own TLS settings, authentication, and an ordered list of
+// mirrors that are tried before the canonical host itself.
+package registry
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Config is the daemon-level registry configuration (registry.config in
+// daemon.json's configuration directory). It replaces the single
+// authConfig-plus-Docker-Hub-defaults model with one resolver entry per
+// host.
+type Config struct {
+	// Endpoints maps a registry hostname (e.g. "docker.io",
+	// "myorg.example.com:5000") to its resolver configuration.
+	Endpoints map[string]HostConfig `json:"endpoints" toml:"endpoints"`
+}
+
+// HostConfig is the resolver configuration for a single registry host.
+type HostConfig struct {
+	// TLS holds the client TLS configuration used to connect to Host and to
+	// Mirrors, unless a given mirror overrides it (not currently supported;
+	// mirrors share the canonical host's TLS settings).
+	TLS *TLSConfig `json:"tls,omitempty" toml:"tls,omitempty"`
+
+	// Auth holds the credentials used to authenticate to Host and Mirrors.
+	Auth *AuthConfig `json:"auth,omitempty" toml:"auth,omitempty"`
+
+	// Mirrors lists additional hosts that are tried, in order, before the
+	// canonical host, e.g. for a pull-through cache.
+	Mirrors []string `json:"mirrors,omitempty" toml:"mirrors,omitempty"`
+}
+
+// TLSConfig holds client TLS settings for a registry endpoint.
+type TLSConfig struct {
+	CAFile             string `json:"ca_file,omitempty" toml:"ca_file,omitempty"`
+	CertFile           string `json:"cert_file,omitempty" toml:"cert_file,omitempty"`
+	KeyFile            string `json:"key_file,omitempty" toml:"key_file,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty" toml:"insecure_skip_verify,omitempty"`
+}
+
+// AuthConfig holds the credentials used to authenticate to a registry
+// endpoint. Exactly one of (Username/Password), IdentityToken, or
+// CredentialHelper is expected to be set.
+type AuthConfig struct {
+	Username      string `json:"username,omitempty" toml:"username,omitempty"`
+	Password      string `json:"password,omitempty" toml:"password,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty" toml:"identitytoken,omitempty"`
+
+	// CredentialHelper, when set, names a `docker-credential-<helper>`-style
+	// binary invoked to produce Username/Password or IdentityToken on
+	// demand, rather than storing them in the config file.
+	CredentialHelper string `json:"credential_helper,omitempty" toml:"credential_helper,omitempty"`
+}
+
+// LoadConfig reads a registry config from path, detecting TOML or JSON by
+// file extension (".toml" or ".json"); any other extension is treated as
+// TOML, matching containerd's hosts.toml convention.
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if filepath.Ext(path) == ".json" {
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return cfg, err
+		}
+		return cfg, nil
+	}
+	if _, err := toml.Decode(string(data), &cfg); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
@@ -0,0 +1,35 @@
+package registry
+
+import "sync/atomic"
+
+// defaultResolver backs DefaultResolver. It starts out empty (every host
+// resolves to itself with no configured auth) so callers can use it before
+// the daemon has loaded a registry config file.
+var defaultResolver atomic.Pointer[Resolver]
+
+func init() {
+	defaultResolver.Store(NewResolver(Config{}))
+}
+
+// DefaultResolver returns the process-wide Resolver that the daemon's
+// registry config (and its SIGHUP reload handler) keeps up to date. Code
+// that talks to a registry host -- image pull, plugin pull, X-Registry-Auth
+// materialization -- should resolve through this Resolver rather than
+// dialing a host directly, so per-host mirrors and credential helpers apply
+// uniformly.
+func DefaultResolver() *Resolver {
+	return defaultResolver.Load()
+}
+
+// ReloadDefault loads the registry config at path and swaps it into the
+// Resolver returned by DefaultResolver, without disturbing endpoints already
+// resolved for in-flight requests. It is the daemon's SIGHUP handler's entry
+// point for reloading registry.config alongside the rest of daemon.json.
+func ReloadDefault(path string) error {
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		return err
+	}
+	DefaultResolver().Reload(cfg)
+	return nil
+}
@@ -0,0 +1,161 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Endpoint is a single resolved endpoint for a host: either a configured
+// mirror or the canonical host itself.
+type Endpoint struct {
+	// Host is the endpoint's address, e.g. "mirror.example.com" or
+	// "docker.io".
+	Host string
+	// Mirror is true for every entry except the last (the canonical host).
+	Mirror bool
+	TLS    *TLSConfig
+}
+
+// Resolver resolves the ordered endpoint list and credentials to use for a
+// registry host, consulting a Config that can be swapped out atomically via
+// Reload (used by the daemon's SIGHUP handler).
+type Resolver struct {
+	mu  sync.RWMutex
+	cfg Config
+
+	// active records, for docker info/debugging, which endpoint last
+	// answered for each host.
+	activeMu sync.Mutex
+	active   map[string]string
+}
+
+// NewResolver returns a Resolver backed by cfg.
+func NewResolver(cfg Config) *Resolver {
+	return &Resolver{cfg: cfg, active: map[string]string{}}
+}
+
+// Reload atomically replaces the configuration a Resolver consults,
+// without invalidating in-flight Endpoints/AuthConfig calls.
+func (r *Resolver) Reload(cfg Config) {
+	r.mu.Lock()
+	r.cfg = cfg
+	r.mu.Unlock()
+}
+
+// Endpoints returns the ordered list of endpoints to try for host: each
+// configured mirror, in the order listed, followed by host itself. When
+// host has no entry in the config, the returned slice has exactly one,
+// non-mirror Endpoint for host.
+func (r *Resolver) Endpoints(host string) []Endpoint {
+	r.mu.RLock()
+	hc, ok := r.cfg.Endpoints[host]
+	r.mu.RUnlock()
+	if !ok {
+		return []Endpoint{{Host: host}}
+	}
+
+	endpoints := make([]Endpoint, 0, len(hc.Mirrors)+1)
+	for _, m := range hc.Mirrors {
+		endpoints = append(endpoints, Endpoint{Host: m, Mirror: true, TLS: hc.TLS})
+	}
+	endpoints = append(endpoints, Endpoint{Host: host, TLS: hc.TLS})
+	return endpoints
+}
+
+// AuthConfig resolves the credentials configured for host (the canonical
+// host the entry is keyed on, not necessarily the mirror actually
+// contacted; mirrors share their canonical host's credentials). It runs the
+// configured credential helper, if any, rather than returning a static
+// secret from the config file.
+func (r *Resolver) AuthConfig(ctx context.Context, host string) (*AuthConfig, error) {
+	r.mu.RLock()
+	hc, ok := r.cfg.Endpoints[host]
+	r.mu.RUnlock()
+	if !ok || hc.Auth == nil {
+		return nil, nil
+	}
+	if hc.Auth.CredentialHelper == "" {
+		return hc.Auth, nil
+	}
+	return runCredentialHelper(ctx, hc.Auth.CredentialHelper, host)
+}
+
+// ResolveForPull tries each of host's configured endpoints in order (mirrors
+// first, canonical host last), calling try for each until one succeeds. The
+// successful endpoint is recorded for ActiveEndpoints/`docker info`. This is
+// the entry point plugin.Pull, image pull, and X-Registry-Auth
+// materialization are expected to use instead of talking to host directly.
+func (r *Resolver) ResolveForPull(ctx context.Context, host string, try func(context.Context, Endpoint) error) (Endpoint, error) {
+	var lastErr error
+	for _, ep := range r.Endpoints(host) {
+		if err := try(ctx, ep); err != nil {
+			lastErr = err
+			continue
+		}
+		r.recordActive(host, ep.Host)
+		return ep, nil
+	}
+	return Endpoint{}, fmt.Errorf("registry: no endpoint for %s succeeded: %w", host, lastErr)
+}
+
+// recordActive notes which endpoint answered a request for host, so it can
+// be surfaced through `docker info` for operators debugging mirror
+// configuration.
+func (r *Resolver) recordActive(host, answeredBy string) {
+	r.activeMu.Lock()
+	r.active[host] = answeredBy
+	r.activeMu.Unlock()
+}
+
+// ActiveEndpoints returns a copy of the host -> last-answering-endpoint map
+// accumulated via recordActive, for `docker info` to display.
+func (r *Resolver) ActiveEndpoints() map[string]string {
+	r.activeMu.Lock()
+	defer r.activeMu.Unlock()
+	out := make(map[string]string, len(r.active))
+	for k, v := range r.active {
+		out[k] = v
+	}
+	return out
+}
+
+// runCredentialHelper invokes `docker-credential-<helper> get`, writing
+// serverURL to its stdin and parsing the {"Username","Secret"} JSON it
+// prints to stdout, following the docker-credential-helpers protocol.
+func runCredentialHelper(ctx context.Context, helper, serverURL string) (*AuthConfig, error) {
+	bin := "docker-credential-" + helper
+	cmd := exec.CommandContext(ctx, bin, "get")
+	cmd.Stdin = strings.NewReader(serverURL)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("registry: credential helper %s failed: %w", bin, err)
+	}
+	return parseCredentialHelperOutput(out)
+}
+
+// credentialHelperOutput is the JSON object docker-credential-helpers'
+// `get` subcommand prints to stdout.
+type credentialHelperOutput struct {
+	ServerURL string
+	Username  string
+	Secret    string
+}
+
+// parseCredentialHelperOutput converts a credential helper's `get` output
+// into an AuthConfig. A Username of "<token>" signals that Secret is an
+// identity token rather than a password, matching the convention used by
+// docker-credential-helpers and docker login.
+func parseCredentialHelperOutput(out []byte) (*AuthConfig, error) {
+	var helperOut credentialHelperOutput
+	if err := json.Unmarshal(out, &helperOut); err != nil {
+		return nil, fmt.Errorf("registry: parsing credential helper output: %w", err)
+	}
+	if helperOut.Username == "<token>" {
+		return &AuthConfig{IdentityToken: helperOut.Secret}, nil
+	}
+	return &AuthConfig{Username: helperOut.Username, Password: helperOut.Secret}, nil
+}
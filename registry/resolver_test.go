@@ -0,0 +1,53 @@
+package registry
+
+import "testing"
+
+func TestEndpointsMirrorsBeforeCanonicalHost(t *testing.T) {
+	r := NewResolver(Config{
+		Endpoints: map[string]HostConfig{
+			"docker.io": {Mirrors: []string{"mirror1.example.com", "mirror2.example.com"}},
+		},
+	})
+
+	got := r.Endpoints("docker.io")
+	want := []string{"mirror1.example.com", "mirror2.example.com", "docker.io"}
+	if len(got) != len(want) {
+		t.Fatalf("got %d endpoints, want %d: %v", len(got), len(want), got)
+	}
+	for i, ep := range got {
+		if ep.Host != want[i] {
+			t.Errorf("endpoint %d = %q, want %q", i, ep.Host, want[i])
+		}
+		if ep.Mirror != (i < len(want)-1) {
+			t.Errorf("endpoint %d Mirror = %v, want %v", i, ep.Mirror, i < len(want)-1)
+		}
+	}
+}
+
+func TestEndpointsUnconfiguredHost(t *testing.T) {
+	r := NewResolver(Config{})
+	got := r.Endpoints("docker.io")
+	if len(got) != 1 || got[0].Host != "docker.io" || got[0].Mirror {
+		t.Fatalf("got %v, want single non-mirror docker.io endpoint", got)
+	}
+}
+
+func TestParseCredentialHelperOutput(t *testing.T) {
+	auth, err := parseCredentialHelperOutput([]byte(`{"ServerURL":"https://example.com","Username":"alice","Secret":"hunter2"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.Username != "alice" || auth.Password != "hunter2" || auth.IdentityToken != "" {
+		t.Errorf("got %+v", auth)
+	}
+}
+
+func TestParseCredentialHelperOutputIdentityToken(t *testing.T) {
+	auth, err := parseCredentialHelperOutput([]byte(`{"ServerURL":"https://example.com","Username":"<token>","Secret":"abc.def.ghi"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.IdentityToken != "abc.def.ghi" || auth.Username != "" || auth.Password != "" {
+		t.Errorf("got %+v", auth)
+	}
+}